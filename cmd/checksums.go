@@ -0,0 +1,339 @@
+package cmd
+
+import (
+  "crypto/md5"
+  "crypto/sha1"
+  "crypto/sha256"
+  "encoding/hex"
+  "errors"
+  "fmt"
+  "hash"
+  "io"
+  "io/fs"
+  "os"
+  "path/filepath"
+  "runtime"
+  "sort"
+  "strings"
+  "sync"
+)
+
+const checksumsMarker = "# Checksums generated with indexify"
+
+var checksumAlgorithms = map[string]func() hash.Hash{
+  "md5": md5.New,
+  "sha1": sha1.New,
+  "sha256": sha256.New,
+}
+
+func isValidChecksumAlgorithm(name string) bool {
+  _, ok := checksumAlgorithms[name]
+  return ok
+}
+
+// checksumsName returns the name of the checksum file, following the
+// coreutils convention of SHA256SUMS, MD5SUMS and so on.
+func (runner *RootCmdRunner) checksumsName() string {
+  return checksumsFileName(runner.checksums)
+}
+
+func checksumsFileName(algorithm string) string {
+  return strings.ToUpper(algorithm) + "SUMS"
+}
+
+// isChecksumsName reports whether name is the checksum file of any of the
+// algorithms.
+func isChecksumsName(name string) bool {
+  for algorithm := range checksumAlgorithms {
+    if name == checksumsFileName(algorithm) {
+      return true
+    }
+  }
+
+  return false
+}
+
+func (ctx *dirContext) checksumsPath() string {
+  return filepath.Join(ctx.outputDir(), ctx.checksumsName())
+}
+
+// fileChecksum streams the file at name, a path in runner.fsys, through the
+// selected hash and returns the hex digest.
+func (runner *RootCmdRunner) fileChecksum(name string) (string, error) {
+  f, err := runner.fsys.Open(name)
+
+  if err != nil {
+    return "", err
+  }
+
+  defer f.Close()
+
+  h := checksumAlgorithms[runner.checksums]()
+  _, err = io.Copy(h, f)
+
+  if err != nil {
+    return "", err
+  }
+
+  return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// computeChecksums hashes the items at the given indexes with up to
+// --hash-jobs files at a time. A file that can't be read is reported and left
+// without a checksum, rather than failing the whole directory.
+func (ctx *dirContext) computeChecksums(indexes []int) {
+  items := ctx.templateData.Items
+  jobs := ctx.hashJobs
+
+  if jobs == 0 {
+    jobs = runtime.NumCPU()
+  }
+
+  queue := make(chan int)
+  var wg sync.WaitGroup
+
+  for i := 0; i < jobs; i++ {
+    wg.Add(1)
+
+    go func() {
+      defer wg.Done()
+
+      // each worker writes to different items, so no locking is needed
+      for index := range queue {
+        checksum, err := ctx.fileChecksum(ctx.itemPath(items[index].Name))
+
+        if err != nil {
+          ctx.logError(err)
+          items[index].checksumFailed = true
+          continue
+        }
+
+        items[index].Checksum = checksum
+      }
+    }()
+  }
+
+  for _, index := range indexes {
+    queue <- index
+  }
+
+  close(queue)
+  wg.Wait()
+}
+
+func (ctx *dirContext) renderChecksums() error {
+  return ctx.renderToFile(
+    ctx.checksumsPath(), isGeneratedChecksums, ctx.writeChecksums,
+  )
+}
+
+// checksumNameEscaper and checksumNameUnescaper escape file names the way
+// coreutils does, for the names that would otherwise break the line apart.
+var checksumNameEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var checksumNameUnescaper = strings.NewReplacer(`\\`, `\`, `\n`, "\n")
+
+// writeChecksums writes a file that `sha256sum --check` and friends accept.
+// They skip lines starting with "#", which is where the marker goes. As with
+// coreutils, a line whose name has a backslash or a newline escaped in it
+// starts with a backslash.
+func (ctx *dirContext) writeChecksums(w io.Writer) error {
+  _, err := fmt.Fprintln(w, checksumsMarker)
+
+  if err != nil {
+    return err
+  }
+
+  for _, item := range ctx.templateData.Items {
+    if item.Checksum == "" {
+      continue
+    }
+
+    prefix := ""
+    name := checksumNameEscaper.Replace(item.Name)
+
+    if name != item.Name {
+      prefix = `\`
+    }
+
+    _, err = fmt.Fprintf(w, "%s%s  %s\n", prefix, item.Checksum, name)
+
+    if err != nil {
+      return err
+    }
+  }
+
+  return nil
+}
+
+func isGeneratedChecksums(data string) bool {
+  return strings.HasPrefix(data, checksumsMarker)
+}
+
+// verifyChecksums compares the files with the checksum files written by an
+// earlier run, for --checksum-verify. Nothing is written, and the run fails
+// if any file differs.
+func (runner *RootCmdRunner) verifyChecksums() error {
+  // thumbnails would be written while fetching the data
+  runner.thumbnails = false
+  differences := 0
+
+  err := runner.walkStartDirs(func(dir string) error {
+    n, err := runner.verifyDirectory(dir)
+    differences += n
+    return err
+  })
+
+  if err != nil {
+    return err
+  }
+
+  if runner.state.stats.errors > 0 {
+    return fmt.Errorf(
+      "some files could not be verified (%d errors)",
+      runner.state.stats.errors,
+    )
+  }
+
+  if differences > 0 {
+    return fmt.Errorf(
+      "%s differ from the checksums", pluralize(differences, "file", "files"),
+    )
+  }
+
+  runner.logf(logNormal, "all files match their checksums")
+  return nil
+}
+
+// verifyDirectory reports the files in dir that differ from its checksum
+// file and returns how many there are.
+func (runner *RootCmdRunner) verifyDirectory(dir string) (int, error) {
+  if runner.skipRoot && runner.isStartDir(dir) {
+    runner.logSkip("starting directory", dir)
+    return 0, nil
+  }
+
+  ctx, err := runner.prepare(dir)
+
+  if err != nil {
+    return 0, err
+  }
+
+  data, err := os.ReadFile(ctx.checksumsPath())
+
+  if errors.Is(err, fs.ErrNotExist) {
+    runner.logSkip("no checksum file", dir)
+    return 0, nil
+  }
+
+  if err != nil {
+    return 0, err
+  }
+
+  stored, err := parseChecksums(string(data))
+
+  if err != nil {
+    return 0, fmt.Errorf("%s: %w", ctx.checksumsPath(), err)
+  }
+
+  err = ctx.fetchData()
+
+  if err != nil {
+    return 0, err
+  }
+
+  current := map[string]string{}
+
+  // the files that are there but couldn't be hashed, which computeChecksums
+  // has reported already
+  unreadable := map[string]bool{}
+
+  for _, item := range ctx.templateData.Items {
+    if item.Checksum != "" {
+      current[item.Name] = item.Checksum
+    }
+
+    if item.checksumFailed {
+      unreadable[item.Name] = true
+      runner.countError()
+    }
+  }
+
+  var names []string
+
+  for name := range stored {
+    names = append(names, name)
+  }
+
+  for name := range current {
+    if _, ok := stored[name]; !ok {
+      names = append(names, name)
+    }
+  }
+
+  sort.Strings(names)
+  differences := 0
+
+  for _, name := range names {
+    var difference string
+    storedSum, wasStored := stored[name]
+    currentSum, isCurrent := current[name]
+
+    switch {
+    case unreadable[name]:
+      continue
+
+    case !wasStored:
+      difference = "added"
+
+    case !isCurrent:
+      difference = "missing"
+
+    case storedSum != currentSum:
+      difference = "changed"
+
+    default:
+      continue
+    }
+
+    path := filepath.Join(dir, name)
+    differences += 1
+
+    runner.emit(
+      logNormal,
+      actionEvent{Action: difference, Path: path},
+      "%s %s", difference, path,
+    )
+  }
+
+  return differences, nil
+}
+
+// parseChecksums reads a checksum file in the format of writeChecksums, as
+// well as the "*" that marks binary mode in the coreutils format, and returns
+// the digests by file name.
+func parseChecksums(data string) (map[string]string, error) {
+  result := map[string]string{}
+
+  for i, line := range strings.Split(data, "\n") {
+    if line == "" || strings.HasPrefix(line, "#") {
+      continue
+    }
+
+    escaped := strings.HasPrefix(line, `\`)
+    sum, name, ok := strings.Cut(strings.TrimPrefix(line, `\`), " ")
+
+    if !ok || !(strings.HasPrefix(name, " ") || strings.HasPrefix(name, "*")) {
+      return nil, fmt.Errorf("invalid checksum line %d", i + 1)
+    }
+
+    name = name[1:]
+
+    if escaped {
+      name = checksumNameUnescaper.Replace(name)
+    }
+
+    result[name] = strings.ToLower(sum)
+  }
+
+  return result, nil
+}
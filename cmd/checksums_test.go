@@ -0,0 +1,149 @@
+package cmd
+
+import (
+  "bytes"
+  "io/fs"
+  "os"
+  "os/exec"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+func TestVerifyChecksumsMismatch(t *testing.T) {
+  root := t.TempDir()
+  writeTree(t, root, map[string]string{"a.txt": "a", "b.txt": "b"})
+
+  if err := runIndexify(t, "-q", "--root", root, "--checksums", "sha256", root); err != nil {
+    t.Fatal(err)
+  }
+
+  if err := runIndexify(t, "-q", "--root", root, "--checksums", "sha256", "--checksum-verify", root); err != nil {
+    t.Fatalf("expected the checksums to match, got %v", err)
+  }
+
+  if err := os.WriteFile(filepath.Join(root, "b.txt"), []byte("changed"), 0644); err != nil {
+    t.Fatal(err)
+  }
+
+  var out bytes.Buffer
+  resetCommand()
+  rootCmd.SetArgs([]string{"-q", "--root", root, "--checksums", "sha256", "--checksum-verify", root})
+  rootCmd.SetOut(&out)
+  rootCmd.SetErr(&out)
+  err := rootCmd.Execute()
+
+  if err == nil || !strings.Contains(err.Error(), "differ from the checksums") {
+    t.Fatalf("expected a mismatch, got %v", err)
+  }
+
+  // only a mistake in the arguments is worth the usage
+  if strings.Contains(out.String(), "Usage:") {
+    t.Errorf("expected no usage after a mismatch, got:\n%s", out.String())
+  }
+}
+
+func TestVerifyChecksumsBadArgsShowUsage(t *testing.T) {
+  var out bytes.Buffer
+  resetCommand()
+  rootCmd.SetArgs([]string{"-q", "--checksum-verify", t.TempDir()})
+  rootCmd.SetOut(&out)
+  rootCmd.SetErr(&out)
+
+  if err := rootCmd.Execute(); err == nil {
+    t.Fatal("expected an error")
+  }
+
+  if !strings.Contains(out.String(), "Usage:") {
+    t.Error("expected the usage after an argument error")
+  }
+}
+
+func TestChecksumsEscapeNames(t *testing.T) {
+  root := t.TempDir()
+  names := []string{"a\nb.txt", `c\d.txt`, "plain.txt"}
+
+  for _, name := range names {
+    if err := os.WriteFile(filepath.Join(root, name), []byte(name), 0644); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  if err := runIndexify(t, "-q", "--root", root, "--checksums", "sha256", root); err != nil {
+    t.Fatal(err)
+  }
+
+  data := readFile(t, filepath.Join(root, "SHA256SUMS"))
+
+  for _, line := range []string{`  a\nb.txt`, `  c\\d.txt`, "  plain.txt"} {
+    if !strings.Contains(data, line + "\n") {
+      t.Errorf("expected %q in:\n%s", line, data)
+    }
+  }
+
+  if n := strings.Count(data, "\n" + `\`); n != 2 {
+    t.Errorf("expected the 2 escaped lines to start with a backslash, got %d in:\n%s", n, data)
+  }
+
+  stored, err := parseChecksums(data)
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  for _, name := range names {
+    if _, ok := stored[name]; !ok {
+      t.Errorf("expected %q to be read back, got %v", name, stored)
+    }
+  }
+
+  if err := runIndexify(t, "-q", "--root", root, "--checksums", "sha256", "--checksum-verify", root); err != nil {
+    t.Errorf("expected the checksums to match, got %v", err)
+  }
+
+  if _, err := exec.LookPath("sha256sum"); err != nil {
+    return
+  }
+
+  check := exec.Command("sha256sum", "--check", "--quiet", "SHA256SUMS")
+  check.Dir = root
+
+  if out, err := check.CombinedOutput(); err != nil {
+    t.Errorf("expected sha256sum to accept the file, got %v:\n%s", err, out)
+  }
+}
+
+// unreadableFS fails to open the file called name, while still listing it.
+type unreadableFS struct {
+  fs.FS
+  name string
+}
+
+func (fsys unreadableFS) Open(name string) (fs.File, error) {
+  if name == fsys.name {
+    return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+  }
+
+  return fsys.FS.Open(name)
+}
+
+func TestVerifyChecksumsUnreadableIsAnError(t *testing.T) {
+  root := t.TempDir()
+  writeTree(t, root, map[string]string{"a.txt": "a", "b.txt": "b"})
+
+  if err := runIndexify(t, "-q", "--root", root, "--checksums", "sha256", root); err != nil {
+    t.Fatal(err)
+  }
+
+  runner := newRunner(t, "-q", "--root", root, "--checksums", "sha256", "--checksum-verify", root)
+  runner.fsys = unreadableFS{FS: runner.fsys, name: "b.txt"}
+  err := runner.verifyChecksums()
+
+  if err == nil || !strings.Contains(err.Error(), "could not be verified (1 errors)") {
+    t.Fatalf("expected b.txt to be an error, got %v", err)
+  }
+
+  if strings.Contains(err.Error(), "differ") {
+    t.Errorf("expected b.txt not to count as missing, got %v", err)
+  }
+}
@@ -0,0 +1,132 @@
+package cmd
+
+import (
+  "errors"
+  "fmt"
+  "io/fs"
+  "os"
+  "path/filepath"
+
+  "github.com/spf13/cobra"
+)
+
+var cleanCmd = &cobra.Command{
+  Use: "clean [dir]...",
+  Short: "Remove generated index files",
+  RunE: rootCmdRunner.Clean,
+  Args: cobra.ArbitraryArgs,
+}
+
+func init() {
+  rootCmd.AddCommand(cleanCmd)
+}
+
+// Clean removes the files indexify generated, leaving any hand-written files
+// with the same names untouched. The options the files were generated with
+// aren't known, so everything any of them could have written is removed:
+// extra pages, gzip copies, backups, feeds, checksum files and, in the root
+// directory, the sitemap and the summary page.
+func (runner *RootCmdRunner) Clean(cmd *cobra.Command, args []string) error {
+  var err error
+
+  // the output directory mirrors the tree below the root
+  if runner.outDir != "" && runner.rootRelative == "" {
+    return fmt.Errorf("--out-dir requires --root")
+  }
+
+  for _, dir := range defaultDirs(args) {
+    // without --root, each directory is the root of its own tree
+    root := runner.rootRelative
+
+    if root == "" {
+      root = dir
+    }
+
+    runner.rootAbsolute, err = filepath.Abs(root)
+
+    if err != nil {
+      return err
+    }
+
+    err = runner.walk(dir, visitedDirs{}, runner.cleanDirectory)
+
+    if err != nil {
+      return err
+    }
+  }
+
+  return nil
+}
+
+func (runner *RootCmdRunner) cleanDirectory(dir string) error {
+  ctx := &dirContext{RootCmdRunner: runner, dirRelative: filepath.Clean(dir)}
+  err := ctx.resolveDirectories()
+
+  if err != nil {
+    return err
+  }
+
+  entries, err := os.ReadDir(ctx.outputDir())
+
+  if errors.Is(err, fs.ErrNotExist) {
+    return nil
+  }
+
+  if err != nil {
+    return err
+  }
+
+  for _, entry := range entries {
+    isGenerated, ok := runner.outputCheck(ctx.dirAbsolute, entry.Name(), true)
+
+    if !ok || isGenerated == nil {
+      continue
+    }
+
+    err = runner.removeGenerated(
+      filepath.Join(ctx.outputDir(), entry.Name()), isGenerated,
+    )
+
+    if err != nil {
+      return err
+    }
+  }
+
+  return nil
+}
+
+// removeGenerated removes the file at path if isGenerated recognizes it. A
+// file that doesn't exist is fine.
+func (runner *RootCmdRunner) removeGenerated(
+  path string,
+  isGenerated func(string) bool,
+) error {
+  _, err := os.Lstat(path)
+
+  if errors.Is(err, fs.ErrNotExist) {
+    return nil
+  }
+
+  if err != nil {
+    return err
+  }
+
+  err = runner.checkRenderTarget(path, isGenerated)
+
+  if isSkipError(err) {
+    runner.logSkipped(err)
+    return nil
+  }
+
+  if err != nil {
+    return err
+  }
+
+  runner.logAction("remove", path)
+
+  if runner.dryRun {
+    return nil
+  }
+
+  return os.Remove(path)
+}
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+  "io/fs"
+  "os"
+  "path/filepath"
+  "sort"
+  "testing"
+)
+
+// listFiles returns the paths of the regular files below root, relative to
+// it.
+func listFiles(t *testing.T, root string) []string {
+  t.Helper()
+  files := []string{}
+
+  err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+    if err != nil || d.IsDir() {
+      return err
+    }
+
+    rel, err := filepath.Rel(root, path)
+    files = append(files, filepath.ToSlash(rel))
+    return err
+  })
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  sort.Strings(files)
+  return files
+}
+
+func TestCleanRemovesEveryOutput(t *testing.T) {
+  root := t.TempDir()
+  writeTree(t, root, map[string]string{
+    "a.txt": "a",
+    "b.txt": "b",
+    "sub/c.txt": "c",
+    "sub/d.txt": "d",
+    "sub/feed.xml": "hand-written",
+  })
+
+  args := []string{
+    "-q", "-r", "--root", root, "--page-size", "1", "--gzip", "--backup",
+    "--rss", "--base-url", "https://example.com", "--checksums", "sha256",
+    "--sitemap", root,
+  }
+
+  // the second run leaves backups of the first one
+  for i := 0; i < 2; i++ {
+    if err := runIndexify(t, args...); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  if err := runIndexify(t, "clean", "-q", "-r", root); err != nil {
+    t.Fatal(err)
+  }
+
+  want := []string{"a.txt", "b.txt", "sub/c.txt", "sub/d.txt", "sub/feed.xml"}
+
+  if got := listFiles(t, root); !equalStrings(got, want) {
+    t.Errorf("expected only the original files to be left, got %v", got)
+  }
+}
+
+func TestCleanOutDir(t *testing.T) {
+  root := t.TempDir()
+  out := t.TempDir()
+  writeTree(t, root, map[string]string{"sub/a.txt": "a"})
+
+  err := runIndexify(t, "-q", "-r", "--root", root, "--out-dir", out, root)
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  if got := listFiles(t, out); len(got) != 2 {
+    t.Fatalf("expected two indexes in the output directory, got %v", got)
+  }
+
+  err = runIndexify(t, "clean", "-q", "-r", "--root", root, "--out-dir", out, root)
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  if got := listFiles(t, out); len(got) != 0 {
+    t.Errorf("expected the output directory to be empty, got %v", got)
+  }
+
+  if _, err := os.Stat(filepath.Join(root, "sub", "a.txt")); err != nil {
+    t.Error(err)
+  }
+}
+
+func TestCleanDryRun(t *testing.T) {
+  root := t.TempDir()
+
+  if err := runIndexify(t, "-q", "--root", root, root); err != nil {
+    t.Fatal(err)
+  }
+
+  if err := runIndexify(t, "clean", "-q", "-n", root); err != nil {
+    t.Fatal(err)
+  }
+
+  if got := listFiles(t, root); !equalStrings(got, []string{"index.html"}) {
+    t.Errorf("a dry run removed files, left %v", got)
+  }
+}
+
+func equalStrings(a []string, b []string) bool {
+  if len(a) != len(b) {
+    return false
+  }
+
+  for i := range a {
+    if a[i] != b[i] {
+      return false
+    }
+  }
+
+  return true
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+  "errors"
+  "fmt"
+  "io/fs"
+  "os"
+
+  "github.com/spf13/cobra"
+  "github.com/spf13/pflag"
+  "gopkg.in/yaml.v3"
+)
+
+const defaultConfigName = "indexify.yaml"
+
+// loadConfig reads defaults for the flags from --config, or indexify.yaml in
+// the current directory if there is one. Keys are flag names. Flags given on
+// the command line take precedence over the config file.
+func loadConfig(cmd *cobra.Command, args []string) error {
+  path := rootCmdRunner.configPath
+  explicit := path != ""
+
+  if !explicit {
+    path = defaultConfigName
+  }
+
+  data, err := os.ReadFile(path)
+
+  if !explicit && errors.Is(err, fs.ErrNotExist) {
+    return nil
+  }
+
+  if err != nil {
+    return err
+  }
+
+  var config map[string]interface{}
+  err = yaml.Unmarshal(data, &config)
+
+  if err != nil {
+    return fmt.Errorf("invalid config %s: %w", path, err)
+  }
+
+  for key, value := range config {
+    err = applyConfigValue(cmd, key, value)
+
+    if err != nil {
+      return fmt.Errorf("invalid config %s: %w", path, err)
+    }
+  }
+
+  return nil
+}
+
+func applyConfigValue(cmd *cobra.Command, key string, value interface{}) error {
+  flag := cmd.Flags().Lookup(key)
+
+  if flag == nil {
+    // the config is shared between commands, so only complain about keys that
+    // don't belong to any of them
+    if cmd.Root().Flags().Lookup(key) == nil {
+      return fmt.Errorf("unknown key: %s", key)
+    }
+
+    return nil
+  }
+
+  if flag.Changed {
+    return nil
+  }
+
+  values, isList := value.([]interface{})
+
+  if !isList {
+    values = []interface{}{value}
+  } else if _, ok := flag.Value.(pflag.SliceValue); !ok {
+    return fmt.Errorf("%s does not take a list", key)
+  }
+
+  for _, v := range values {
+    err := cmd.Flags().Set(key, fmt.Sprint(v))
+
+    if err != nil {
+      return fmt.Errorf("%s: %w", key, err)
+    }
+  }
+
+  return nil
+}
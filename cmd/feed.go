@@ -0,0 +1,111 @@
+package cmd
+
+import (
+  "encoding/xml"
+  "io"
+  "path/filepath"
+  "sort"
+  "strings"
+  "time"
+)
+
+const feedName = "feed.xml"
+
+type rssFeed struct {
+  XMLName xml.Name `xml:"rss"`
+  Version string `xml:"version,attr"`
+  Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+  Title string `xml:"title"`
+  Link string `xml:"link"`
+  Description string `xml:"description"`
+  Generator string `xml:"generator"`
+  Items []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+  Title string `xml:"title"`
+  Link string `xml:"link"`
+  GUID string `xml:"guid"`
+  PubDate string `xml:"pubDate"`
+}
+
+// renderFeed writes an RSS 2.0 feed of the most recently modified files next
+// to the index. RSS links have to be absolute, which is why --rss requires
+// --base-url.
+func (ctx *dirContext) renderFeed() error {
+  return ctx.renderToFile(
+    ctx.feedPath(), isGeneratedFeed, ctx.writeFeed,
+  )
+}
+
+func (ctx *dirContext) feedPath() string {
+  return filepath.Join(ctx.outputDir(), feedName)
+}
+
+func (ctx *dirContext) writeFeed(w io.Writer) error {
+  feed := rssFeed{
+    Version: "2.0",
+    Channel: rssChannel{
+      Title: ctx.templateData.Name,
+      Link: ctx.indexURL(),
+      Description: ctx.templateData.Name,
+      Generator: generatorName,
+      Items: ctx.feedItems(),
+    },
+  }
+
+  _, err := io.WriteString(w, xml.Header)
+
+  if err != nil {
+    return err
+  }
+
+  enc := xml.NewEncoder(w)
+  enc.Indent("", "  ")
+  err = enc.Encode(feed)
+
+  if err != nil {
+    return err
+  }
+
+  _, err = io.WriteString(w, "\n")
+  return err
+}
+
+func (ctx *dirContext) feedItems() []rssItem {
+  var files []DirectoryItem
+
+  for _, item := range ctx.templateData.Items {
+    if !item.IsDir {
+      files = append(files, item)
+    }
+  }
+
+  sort.SliceStable(files, func(i, j int) bool {
+    return files[i].ModTime.After(files[j].ModTime)
+  })
+
+  if len(files) > ctx.rssLimit {
+    files = files[:ctx.rssLimit]
+  }
+
+  result := make([]rssItem, len(files))
+
+  for i, item := range files {
+    result[i] = rssItem{
+      Title: item.Name,
+      Link: item.URL,
+      GUID: item.URL,
+      PubDate: item.ModTime.Format(time.RFC1123Z),
+    }
+  }
+
+  return result
+}
+
+func isGeneratedFeed(data string) bool {
+  return strings.Contains(data, "<generator>" + generatorName + "</generator>")
+}
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+  "encoding/xml"
+  "path/filepath"
+  "testing"
+)
+
+func TestFeedRequiresBaseURL(t *testing.T) {
+  root := t.TempDir()
+  err := runIndexify(t, "-q", "--root", root, "--rss", root)
+
+  if err == nil || err.Error() != "--rss requires --base-url" {
+    t.Fatalf("expected --rss to require --base-url, got %v", err)
+  }
+}
+
+func TestFeedLinksAreAbsolute(t *testing.T) {
+  root := t.TempDir()
+  writeTree(t, root, map[string]string{"sub/a b.txt": "a"})
+
+  err := runIndexify(
+    t, "-q", "--root", root, "--rss", "--base-url", "https://example.com/files",
+    filepath.Join(root, "sub"),
+  )
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  var feed rssFeed
+  err = xml.Unmarshal([]byte(readFile(t, filepath.Join(root, "sub", feedName))), &feed)
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  if feed.Channel.Link != "https://example.com/files/sub/" {
+    t.Errorf("channel link: %s", feed.Channel.Link)
+  }
+
+  if len(feed.Channel.Items) != 1 {
+    t.Fatalf("expected 1 item, got %d", len(feed.Channel.Items))
+  }
+
+  if link := feed.Channel.Items[0].Link; link != "https://example.com/files/sub/a%20b.txt" {
+    t.Errorf("item link: %s", link)
+  }
+}
@@ -0,0 +1,244 @@
+package cmd
+
+import (
+  "bufio"
+  "os"
+  "path/filepath"
+  "regexp"
+  "strings"
+)
+
+// gitignoreFile holds the rules of a single .gitignore. Patterns are matched
+// against paths relative to base, the directory containing the file.
+type gitignoreFile struct {
+  base string
+  rules []gitignoreRule
+}
+
+type gitignoreRule struct {
+  re *regexp.Regexp
+  negate bool
+  dirOnly bool
+}
+
+// isGitignored reports whether the entry at absPath is ignored by the
+// .gitignore files between the root directory and the entry's parent, either
+// itself or because a directory it's in is. As in git, rules from deeper
+// files take precedence and the last matching rule wins, so a negated rule
+// can re-include an entry, but not one whose directory is ignored.
+func (runner *RootCmdRunner) isGitignored(absPath string, isDir bool) bool {
+  rel, err := filepath.Rel(runner.rootAbsolute, filepath.Dir(absPath))
+
+  if err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+    dir := runner.rootAbsolute
+
+    for _, part := range strings.Split(rel, string(filepath.Separator)) {
+      dir = filepath.Join(dir, part)
+
+      if runner.matchesGitignore(dir, true) {
+        return true
+      }
+    }
+  }
+
+  return runner.matchesGitignore(absPath, isDir)
+}
+
+// matchesGitignore reports whether the rules ignore the entry at absPath
+// itself, regardless of the directories it's in.
+func (runner *RootCmdRunner) matchesGitignore(absPath string, isDir bool) bool {
+  ignored := false
+
+  for _, file := range runner.gitignoreFilesFor(filepath.Dir(absPath)) {
+    rel, err := filepath.Rel(file.base, absPath)
+
+    if err != nil {
+      continue
+    }
+
+    rel = filepath.ToSlash(rel)
+
+    for _, rule := range file.rules {
+      if rule.dirOnly && !isDir {
+        continue
+      }
+
+      if rule.re.MatchString(rel) {
+        ignored = !rule.negate
+      }
+    }
+  }
+
+  return ignored
+}
+
+// gitignoreFilesFor returns the .gitignore files that apply to entries of dir,
+// ordered from the root directory down to dir itself. Parsed files are cached
+// since a recursive run asks for the same ancestors over and over.
+func (runner *RootCmdRunner) gitignoreFilesFor(dir string) []*gitignoreFile {
+  var result []*gitignoreFile
+
+  rel, err := filepath.Rel(runner.rootAbsolute, dir)
+
+  if err != nil || strings.HasPrefix(rel, "..") {
+    return nil
+  }
+
+  current := runner.rootAbsolute
+  parts := []string{""}
+
+  if rel != "." {
+    parts = append(parts, strings.Split(rel, string(filepath.Separator))...)
+  }
+
+  for _, part := range parts {
+    current = filepath.Join(current, part)
+
+    if file := runner.loadGitignore(current); file != nil {
+      result = append(result, file)
+    }
+  }
+
+  return result
+}
+
+func (runner *RootCmdRunner) loadGitignore(dir string) *gitignoreFile {
+  state := runner.state
+  state.mu.Lock()
+  defer state.mu.Unlock()
+
+  if file, ok := state.gitignoreCache[dir]; ok {
+    return file
+  }
+
+  file := parseGitignore(dir)
+  state.gitignoreCache[dir] = file
+  return file
+}
+
+// parseGitignore reads dir/.gitignore, returning nil if there isn't one.
+func parseGitignore(dir string) *gitignoreFile {
+  f, err := os.Open(filepath.Join(dir, ".gitignore"))
+
+  if err != nil {
+    return nil
+  }
+
+  defer f.Close()
+
+  file := &gitignoreFile{base: dir}
+  scanner := bufio.NewScanner(f)
+
+  for scanner.Scan() {
+    if rule, ok := parseGitignoreLine(scanner.Text()); ok {
+      file.rules = append(file.rules, rule)
+    }
+  }
+
+  return file
+}
+
+func parseGitignoreLine(line string) (gitignoreRule, bool) {
+  var rule gitignoreRule
+
+  line = strings.TrimSuffix(line, "\r")
+
+  // trailing spaces are ignored unless escaped
+  for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+    line = line[:len(line)-1]
+  }
+
+  if line == "" || strings.HasPrefix(line, "#") {
+    return rule, false
+  }
+
+  if strings.HasPrefix(line, "!") {
+    rule.negate = true
+    line = line[1:]
+  } else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+    line = line[1:]
+  }
+
+  if strings.HasSuffix(line, "/") {
+    rule.dirOnly = true
+    line = strings.TrimSuffix(line, "/")
+  }
+
+  if line == "" {
+    return rule, false
+  }
+
+  // a slash at the beginning or in the middle anchors the pattern to the
+  // directory of the .gitignore, otherwise it matches at any depth
+  anchored := strings.Contains(line, "/")
+  line = strings.TrimPrefix(line, "/")
+
+  expr := gitignorePatternToRegexp(line)
+
+  if !anchored && !strings.HasPrefix(expr, "(?:.*/)?") {
+    expr = "(?:.*/)?" + expr
+  }
+
+  re, err := regexp.Compile("^" + expr + "$")
+
+  if err != nil {
+    return rule, false
+  }
+
+  rule.re = re
+  return rule, true
+}
+
+func gitignorePatternToRegexp(pattern string) string {
+  var sb strings.Builder
+
+  for i := 0; i < len(pattern); i++ {
+    c := pattern[i]
+
+    switch {
+    case strings.HasPrefix(pattern[i:], "**/"):
+      sb.WriteString("(?:.*/)?")
+      i += 2
+
+    case strings.HasPrefix(pattern[i:], "/**") && i+3 == len(pattern):
+      sb.WriteString("/.*")
+      i += 2
+
+    case strings.HasPrefix(pattern[i:], "**") && i+2 == len(pattern):
+      sb.WriteString(".*")
+      i += 1
+
+    case c == '*':
+      sb.WriteString("[^/]*")
+
+    case c == '?':
+      sb.WriteString("[^/]")
+
+    case c == '\\' && i+1 < len(pattern):
+      i++
+      sb.WriteString(regexp.QuoteMeta(string(pattern[i])))
+
+    case c == '[':
+      end := strings.IndexByte(pattern[i+1:], ']')
+
+      if end < 0 {
+        sb.WriteString("\\[")
+        continue
+      }
+
+      class := pattern[i+1 : i+1+end]
+
+      if strings.HasPrefix(class, "!") {
+        class = "^" + class[1:]
+      }
+
+      sb.WriteString("[" + class + "]")
+      i += end + 1
+
+    default:
+      sb.WriteString(regexp.QuoteMeta(string(c)))
+    }
+  }
+
+  return sb.String()
+}
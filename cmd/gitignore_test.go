@@ -0,0 +1,39 @@
+package cmd
+
+import (
+  "path/filepath"
+  "testing"
+)
+
+func TestGitignoreInsideIgnoredDirectory(t *testing.T) {
+  root := t.TempDir()
+  writeTree(t, root, map[string]string{
+    ".gitignore": "build/\n!keep.txt\n",
+    "build/sub/a.txt": "a",
+    "build/sub/keep.txt": "k",
+    "src/keep.txt": "k",
+    "src/b.txt": "b",
+  })
+
+  tests := []struct {
+    dir string
+    want []string
+  }{
+    // git doesn't look inside build at all, so keep.txt isn't re-included
+    {"build/sub", nil},
+    {"src", []string{"b.txt", "keep.txt"}},
+  }
+
+  for _, tt := range tests {
+    dir := filepath.Join(root, filepath.FromSlash(tt.dir))
+    err := runIndexify(t, "-q", "--gitignore", "--root", root, "--format", "json", dir)
+
+    if err != nil {
+      t.Fatal(err)
+    }
+
+    if got := itemNames(readListing(t, dir).Items); !equalStrings(got, tt.want) {
+      t.Errorf("%s: expected %v, got %v", tt.dir, tt.want, got)
+    }
+  }
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+  "bytes"
+  "os/exec"
+  "strings"
+  "time"
+)
+
+// gitModTimes returns the author date of the most recent commit touching
+// each entry of the current directory, keyed by name. A directory gets the
+// date of the latest commit to anything below it. All of it comes from a
+// single git log, so the cost doesn't grow with the number of files. Outside
+// of a git work tree, or without git installed, the result is empty and the
+// filesystem times are used instead.
+func (ctx *dirContext) gitModTimes() map[string]time.Time {
+  cmd := exec.Command(
+    "git", "-C", ctx.dirAbsolute,
+    "log", "-z", "--no-renames", "--relative", "--name-only",
+    "--format=%x01%aI", "--", ".",
+  )
+
+  var stderr bytes.Buffer
+  cmd.Stderr = &stderr
+
+  out, err := cmd.Output()
+
+  if err != nil {
+    ctx.logf(
+      logVerbose, "%s: not using git times: %s",
+      ctx.dirRelative, strings.TrimSpace(stderr.String()),
+    )
+
+    return nil
+  }
+
+  result := make(map[string]time.Time)
+  var commitTime time.Time
+
+  for _, field := range strings.Split(string(out), "\x00") {
+    field = strings.TrimPrefix(field, "\n")
+
+    if strings.HasPrefix(field, "\x01") {
+      commitTime, err = time.Parse(time.RFC3339, field[1:])
+
+      if err != nil {
+        commitTime = time.Time{}
+      }
+
+      continue
+    }
+
+    if field == "" || commitTime.IsZero() {
+      continue
+    }
+
+    name, _, _ := strings.Cut(field, "/")
+
+    // author dates aren't necessarily in log order, e.g. after a rebase
+    if commitTime.After(result[name]) {
+      result[name] = commitTime
+    }
+  }
+
+  return result
+}
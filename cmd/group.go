@@ -0,0 +1,80 @@
+package cmd
+
+import (
+  "path/filepath"
+  "strings"
+)
+
+type ItemGroup struct {
+  Name string `json:"name"`
+  Items []DirectoryItem `json:"items"`
+
+  // with --stream, the items arrive here one at a time instead
+  stream <-chan *DirectoryItem
+}
+
+// Rows is what templates range over: the items, or with --stream, the channel
+// they arrive on as they are read.
+func (g ItemGroup) Rows() interface{} {
+  if g.stream != nil {
+    return g.stream
+  }
+
+  return g.Items
+}
+
+// typeGroups are the sections of --group-by type, in the order they are
+// shown in.
+var typeGroups = []string{"Folders", "Images", "Archives", "Other"}
+
+var archiveExts = []string{
+  ".zip", ".tar", ".gz", ".tgz", ".bz2", ".xz", ".zst", ".7z", ".rar",
+}
+
+// groupItems splits the items into the sections of --group-by, keeping their
+// order within each section. Empty sections are left out. Without
+// --group-by, there's a single section without a name.
+func (runner *RootCmdRunner) groupItems(items []DirectoryItem) []ItemGroup {
+  if runner.groupBy == "" {
+    return []ItemGroup{{Items: items}}
+  }
+
+  byType := map[string][]DirectoryItem{}
+
+  for _, item := range items {
+    t := itemType(item)
+    byType[t] = append(byType[t], item)
+  }
+
+  var groups []ItemGroup
+
+  for _, name := range typeGroups {
+    if len(byType[name]) > 0 {
+      groups = append(groups, ItemGroup{Name: name, Items: byType[name]})
+    }
+  }
+
+  return groups
+}
+
+// itemType classifies an item into one of the typeGroups, by the detected
+// mime type and, failing that, the extension.
+func itemType(item DirectoryItem) string {
+  if item.IsDir {
+    return "Folders"
+  }
+
+  if strings.HasPrefix(item.MimeType, "image/") {
+    return "Images"
+  }
+
+  ext := strings.ToLower(filepath.Ext(item.Name))
+
+  for _, archiveExt := range archiveExts {
+    if ext == archiveExt {
+      return "Archives"
+    }
+  }
+
+  return "Other"
+}
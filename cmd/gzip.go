@@ -0,0 +1,61 @@
+package cmd
+
+import (
+  "bytes"
+  "compress/gzip"
+  "io"
+  "strings"
+)
+
+const gzipSuffix = ".gz"
+
+// renderGzip writes a gzip-compressed copy of the file at path next to it, for
+// web servers that serve precompressed files. The copy is only overwritten if
+// it decompresses to something isGenerated recognizes.
+func (runner *RootCmdRunner) renderGzip(
+  path string,
+  isGenerated func(string) bool,
+  write func(io.Writer) error,
+) error {
+  return runner.renderToFile(
+    path + gzipSuffix,
+    func(data string) bool {
+      return isGenerated(gunzipString(data))
+    },
+    func(w io.Writer) error {
+      // without a name or a modification time in the header, the same input
+      // always compresses to the same bytes
+      zw, err := gzip.NewWriterLevel(w, gzip.BestCompression)
+
+      if err != nil {
+        return err
+      }
+
+      err = write(zw)
+
+      if err != nil {
+        return err
+      }
+
+      return zw.Close()
+    },
+  )
+}
+
+// gunzipString returns the decompressed data, or "" if it isn't valid gzip.
+func gunzipString(data string) string {
+  zr, err := gzip.NewReader(strings.NewReader(data))
+
+  if err != nil {
+    return ""
+  }
+
+  buf := new(bytes.Buffer)
+  _, err = buf.ReadFrom(zr)
+
+  if err != nil {
+    return ""
+  }
+
+  return buf.String()
+}
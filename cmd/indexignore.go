@@ -0,0 +1,52 @@
+package cmd
+
+import (
+  "errors"
+  "io/fs"
+  "path"
+  "path/filepath"
+  "strings"
+)
+
+// indexignoreName is a file of glob patterns, one per line, for entries to
+// leave out of the listing of the directory it's in.
+const indexignoreName = ".indexignore"
+
+// readIndexignore returns the patterns in the .indexignore of dir, a path in
+// fsys, if it has one. Blank lines and lines starting with # are skipped.
+func readIndexignore(fsys fs.FS, dir string) ([]string, error) {
+  data, err := fs.ReadFile(fsys, path.Join(dir, indexignoreName))
+
+  if errors.Is(err, fs.ErrNotExist) {
+    return nil, nil
+  }
+
+  if err != nil {
+    return nil, err
+  }
+
+  var patterns []string
+
+  for _, line := range strings.Split(string(data), "\n") {
+    line = strings.TrimSpace(line)
+
+    if line == "" || strings.HasPrefix(line, "#") {
+      continue
+    }
+
+    patterns = append(patterns, line)
+  }
+
+  return patterns, nil
+}
+
+// matchesAny reports whether name matches any of the glob patterns.
+func matchesAny(patterns []string, name string) bool {
+  for _, pattern := range patterns {
+    if matched, _ := filepath.Match(pattern, name); matched {
+      return true
+    }
+  }
+
+  return false
+}
@@ -0,0 +1,206 @@
+package cmd
+
+import (
+  "encoding/json"
+  "errors"
+  "fmt"
+  "os"
+)
+
+type logLevel int
+
+const (
+  logQuiet logLevel = iota
+  logNormal
+  logVerbose
+)
+
+func (runner *RootCmdRunner) logLevel() logLevel {
+  switch {
+  case runner.quiet:
+    return logQuiet
+
+  case runner.verbose:
+    return logVerbose
+  }
+
+  return logNormal
+}
+
+// The events written by --log-json, one JSON object per line. Each has an
+// action saying what kind of event it is.
+
+type messageEvent struct {
+  Action string `json:"action"`
+  Message string `json:"message"`
+}
+
+type actionEvent struct {
+  Action string `json:"action"`
+  Path string `json:"path"`
+  DryRun bool `json:"dryRun,omitempty"`
+}
+
+type skipEvent struct {
+  Action string `json:"action"`
+  Path string `json:"path,omitempty"`
+  Reason string `json:"reason"`
+  Error string `json:"error,omitempty"`
+}
+
+type errorEvent struct {
+  Action string `json:"action"`
+  Error string `json:"error"`
+}
+
+type indexEvent struct {
+  Action string `json:"action"`
+  Path string `json:"path"`
+  Dirs int `json:"dirs"`
+  Files int `json:"files"`
+  Items int `json:"items"`
+}
+
+type summaryEvent struct {
+  Action string `json:"action"`
+  Written int `json:"written"`
+  Skipped map[string]int `json:"skipped"`
+  Files int `json:"files"`
+  Errors int `json:"errors"`
+  Seconds float64 `json:"seconds"`
+}
+
+// emit is where all status output goes through. If --quiet and --verbose
+// allow for level, it prints a line made from format and args, or with
+// --log-json, event as JSON on stderr.
+func (runner *RootCmdRunner) emit(
+  level logLevel,
+  event interface{},
+  format string,
+  args ...interface{},
+) {
+  if runner.logLevel() < level {
+    return
+  }
+
+  runner.aroundProgress(func() {
+    if runner.logJSON {
+      // a value made of strings and numbers can't fail to encode
+      line, _ := json.Marshal(event)
+      fmt.Fprintln(os.Stderr, string(line))
+      return
+    }
+
+    fmt.Printf(format + "\n", args...)
+  })
+}
+
+// logf prints a status line if --quiet and --verbose allow for level. Errors
+// aren't logged here, they are returned and reported by cobra.
+func (runner *RootCmdRunner) logf(level logLevel, format string, args ...interface{}) {
+  runner.emit(
+    level,
+    messageEvent{Action: "message", Message: fmt.Sprintf(format, args...)},
+    format, args...,
+  )
+}
+
+// logAction logs what was, or with --dry-run would have been, done to path.
+// Dry runs are logged by default since that's their whole point.
+func (runner *RootCmdRunner) logAction(action string, path string) {
+  event := actionEvent{Action: action, Path: path, DryRun: runner.dryRun}
+
+  if runner.dryRun {
+    runner.emit(logNormal, event, "[dry-run] %s %s", action, path)
+    return
+  }
+
+  runner.emit(logVerbose, event, "%s %s", action, path)
+}
+
+// logSkip logs a directory that the walk leaves out, and why.
+func (runner *RootCmdRunner) logSkip(reason string, path string) {
+  runner.emit(
+    logVerbose,
+    skipEvent{Action: "skip", Path: path, Reason: reason},
+    "skip %s %s", reason, path,
+  )
+}
+
+// logSkipped logs a file that was left alone, see isSkipError. With
+// --quiet-skips, these only show up with --verbose.
+func (runner *RootCmdRunner) logSkipped(err error) {
+  level := logNormal
+
+  if runner.quietSkips {
+    level = logVerbose
+  }
+
+  runner.emit(
+    level,
+    skipEvent{Action: "skip", Reason: skipReason(err), Error: err.Error()},
+    "skipped: %s", err,
+  )
+}
+
+// hintHandWritten explains what to do about a hand-written file in the way of
+// an index, the first time one turns up in a run. It's shown along with the
+// skip itself, so --quiet-skips hides it as well.
+func (runner *RootCmdRunner) hintHandWritten(err error) {
+  if !errors.Is(err, errTargetExistsAndIsNotGenerated) {
+    return
+  }
+
+  state := runner.state
+  state.mu.Lock()
+  hinted := state.hintedHandWritten
+  state.hintedHandWritten = true
+  state.mu.Unlock()
+
+  if hinted {
+    return
+  }
+
+  level := logNormal
+
+  if runner.quietSkips {
+    level = logVerbose
+  }
+
+  message := fmt.Sprintf(
+    "files without the marker %q are never overwritten. To keep them, "+
+      "choose another --index-name; to replace them, add the marker to "+
+      "them or pass a --marker they contain",
+    runner.marker,
+  )
+
+  runner.emit(
+    level,
+    messageEvent{Action: "hint", Message: message},
+    "hint: %s", message,
+  )
+}
+
+// logError reports an error that doesn't stop the run, as with --keep-going
+// and --watch. These are printed even with --quiet.
+func (runner *RootCmdRunner) logError(err error) {
+  runner.aroundProgress(func() {
+    if runner.logJSON {
+      line, _ := json.Marshal(errorEvent{Action: "error", Error: err.Error()})
+      fmt.Fprintln(os.Stderr, string(line))
+      return
+    }
+
+    fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+  })
+}
+
+// aroundProgress calls print with the --progress line out of the way.
+func (runner *RootCmdRunner) aroundProgress(print func()) {
+  if runner.state == nil {
+    print()
+    return
+  }
+
+  runner.state.progress.interrupt(print)
+}
@@ -0,0 +1,181 @@
+package cmd
+
+import (
+  "strings"
+)
+
+// rawTextElements are copied verbatim by minifyHTML since whitespace inside
+// them is significant, or they aren't html at all.
+var rawTextElements = []string{"pre", "script", "style", "textarea"}
+
+// blockElements are the elements that whitespace around is never rendered
+// for, so that it can be dropped between two of their tags.
+var blockElements = map[string]bool{
+  "!doctype": true, "address": true, "article": true, "aside": true,
+  "base": true, "blockquote": true, "body": true, "dd": true, "details": true,
+  "dialog": true, "div": true, "dl": true, "dt": true, "fieldset": true,
+  "figcaption": true, "figure": true, "footer": true, "form": true,
+  "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+  "head": true, "header": true, "hr": true, "html": true, "li": true,
+  "link": true, "main": true, "meta": true, "nav": true, "ol": true,
+  "p": true, "section": true, "summary": true, "table": true, "tbody": true,
+  "td": true, "tfoot": true, "th": true, "thead": true, "title": true,
+  "tr": true, "ul": true,
+}
+
+// minifyHTML strips comments from html and collapses whitespace between tags.
+// Runs of whitespace become a single space, which is how the browser renders
+// them anyway, and runs that are all whitespace are dropped between two tags
+// of blockElements. Tags, including their attribute values, and the contents
+// of rawTextElements are left untouched.
+func minifyHTML(html string) string {
+  var sb strings.Builder
+  sb.Grow(len(html))
+
+  // the last tag written, to tell whether whitespace after it is rendered
+  var prevTag string
+
+  for len(html) > 0 {
+    switch {
+    case strings.HasPrefix(html, "<!--"):
+      end := strings.Index(html[4:], "-->")
+
+      if end < 0 {
+        return sb.String()
+      }
+
+      html = html[4+end+3:]
+
+    case html[0] == '<':
+      tagEnd := indexTagEnd(html)
+      tag := html[:tagEnd]
+      sb.WriteString(tag)
+      prevTag = tag
+      html = html[tagEnd:]
+
+      if name := rawTextElement(tag); name != "" {
+        end := strings.Index(strings.ToLower(html), "</" + name)
+
+        if end < 0 {
+          end = len(html)
+        }
+
+        sb.WriteString(html[:end])
+        html = html[end:]
+      }
+
+    default:
+      end := strings.IndexByte(html, '<')
+
+      if end < 0 {
+        end = len(html)
+      }
+
+      text := html[:end]
+      html = html[end:]
+
+      // nothing is rendered before the first tag or after the last one
+      atBlock := prevTag == "" || isBlockTag(prevTag)
+
+      if isSpace(text) && atBlock && (html == "" || isBlockTag(html)) {
+        continue
+      }
+
+      sb.WriteString(collapseWhitespace(text))
+    }
+  }
+
+  return sb.String()
+}
+
+// indexTagEnd returns the index just past the '>' that closes the tag at the
+// start of html, skipping over quoted attribute values.
+func indexTagEnd(html string) int {
+  var quote byte
+
+  for i := 1; i < len(html); i++ {
+    c := html[i]
+
+    switch {
+    case quote != 0:
+      if c == quote {
+        quote = 0
+      }
+
+    case c == '"' || c == '\'':
+      quote = c
+
+    case c == '>':
+      return i + 1
+    }
+  }
+
+  return len(html)
+}
+
+// rawTextElement returns the name of the raw text element tag opens, if any.
+func rawTextElement(tag string) string {
+  name := strings.ToLower(strings.TrimPrefix(tag, "<"))
+
+  for _, raw := range rawTextElements {
+    if !strings.HasPrefix(name, raw) {
+      continue
+    }
+
+    rest := name[len(raw):]
+
+    if rest == "" || strings.IndexByte(" \t\r\n>/", rest[0]) >= 0 {
+      return raw
+    }
+  }
+
+  return ""
+}
+
+// isBlockTag reports whether html starts with a tag of blockElements, opening
+// or closing.
+func isBlockTag(html string) bool {
+  if !strings.HasPrefix(html, "<") {
+    return false
+  }
+
+  name := strings.TrimPrefix(html[1:], "/")
+  end := strings.IndexAny(name, " \t\r\n/>")
+
+  if end >= 0 {
+    name = name[:end]
+  }
+
+  return blockElements[strings.ToLower(name)]
+}
+
+func isSpace(text string) bool {
+  return strings.Trim(text, " \t\n\r\f") == ""
+}
+
+// collapseWhitespace replaces every run of whitespace in text with a single
+// space.
+func collapseWhitespace(text string) string {
+  var sb strings.Builder
+  space := false
+
+  for _, r := range text {
+    if r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == '\f' {
+      space = true
+      continue
+    }
+
+    if space {
+      sb.WriteByte(' ')
+      space = false
+    }
+
+    sb.WriteRune(r)
+  }
+
+  if space {
+    sb.WriteByte(' ')
+  }
+
+  return sb.String()
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+  "path/filepath"
+  "regexp"
+  "strings"
+  "testing"
+)
+
+func TestMinifyHTML(t *testing.T) {
+  tests := []struct {
+    html string
+    want string
+  }{
+    {"<ul>\n  <li>a</li>\n  <li>b</li>\n</ul>\n", "<ul><li>a</li><li>b</li></ul>"},
+    {"<a>a</a>\n<a>b</a>", "<a>a</a> <a>b</a>"},
+    {"<span>/</span>\n  <span>b</span>", "<span>/</span> <span>b</span>"},
+    {"<p>\n  some\n  text\n</p>", "<p> some text </p>"},
+    {"<p>a<!-- note --></p>", "<p>a</p>"},
+    {"<pre>\n  a\n</pre>", "<pre>\n  a\n</pre>"},
+    {"<div title=\"a  <b>\">\n</div>", "<div title=\"a  <b>\"></div>"},
+  }
+
+  for _, tt := range tests {
+    if got := minifyHTML(tt.html); got != tt.want {
+      t.Errorf("minifyHTML(%q) = %q, want %q", tt.html, got, tt.want)
+    }
+  }
+}
+
+// renderedText approximates what the browser shows of html: the text outside
+// tags and comments, with block elements as line breaks and every other run of
+// whitespace as a single space.
+func renderedText(html string) string {
+  html = regexp.MustCompile(`(?s)<!--.*?-->`).ReplaceAllString(html, "")
+
+  // the source's own line breaks are just whitespace, so block elements are
+  // marked with a character that can't be in the page
+  html = regexp.MustCompile(`<[^>]*>`).ReplaceAllStringFunc(html, func(tag string) string {
+    if isBlockTag(tag) {
+      return "\x00"
+    }
+
+    return ""
+  })
+
+  var lines []string
+
+  for _, line := range strings.Split(collapseWhitespace(html), "\x00") {
+    if line = strings.TrimSpace(line); line != "" {
+      lines = append(lines, line)
+    }
+  }
+
+  return strings.Join(lines, "\n")
+}
+
+func TestMinifyKeepsInlineSpacing(t *testing.T) {
+  root := t.TempDir()
+  writeTree(t, root, map[string]string{"a/b/one.txt": "1", "a/b/two.txt": "2", "a/b/c/": ""})
+  dir := filepath.Join(root, "a", "b")
+
+  for _, themeName := range []string{"table", "grid"} {
+    var pages []string
+
+    for _, args := range [][]string{{}, {"--minify"}} {
+      args = append(args, "-q", "--theme-name", themeName, "--root", root, dir)
+
+      if err := runIndexify(t, args...); err != nil {
+        t.Fatal(err)
+      }
+
+      pages = append(pages, readFile(t, filepath.Join(dir, "index.html")))
+    }
+
+    if len(pages[1]) >= len(pages[0]) {
+      t.Errorf("%s: expected the minified page to be smaller", themeName)
+    }
+
+    if plain, minified := renderedText(pages[0]), renderedText(pages[1]); plain != minified {
+      t.Errorf("%s: expected the same text with --minify, got\n%s\nwant\n%s", themeName, minified, plain)
+    }
+  }
+}
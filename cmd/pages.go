@@ -0,0 +1,143 @@
+package cmd
+
+import (
+  "errors"
+  "fmt"
+  "io/fs"
+  "os"
+  "path"
+  "path/filepath"
+  "strconv"
+  "strings"
+)
+
+// truncated returns the listing cut down to --max-items. The counts, as well
+// as the feed and the checksums, still cover all of the items.
+func (ctx *dirContext) truncated() IndexTemplate {
+  data := ctx.templateData
+
+  if ctx.maxItems > 0 && len(data.Items) > ctx.maxItems {
+    data.HiddenItemCount = len(data.Items) - ctx.maxItems
+    data.Items = data.Items[:ctx.maxItems]
+  }
+
+  return data
+}
+
+// pages splits the items into pages of --page-size items. Without a page
+// size, or when everything fits, there's a single page with all the items.
+// The overall counts stay the same on every page. --max-items is applied
+// first, only the items it leaves are paginated, and the number of items
+// left out is shown on the last page.
+func (ctx *dirContext) pages() []IndexTemplate {
+  listing := ctx.truncated()
+  items := listing.Items
+  totalPages := 1
+
+  if ctx.pageSize > 0 && len(items) > ctx.pageSize {
+    totalPages = (len(items) + ctx.pageSize - 1) / ctx.pageSize
+  }
+
+  result := make([]IndexTemplate, totalPages)
+
+  for i := range result {
+    page := listing
+    page.PageNum = i + 1
+    page.TotalPages = totalPages
+
+    if totalPages > 1 {
+      start := i * ctx.pageSize
+      end := start + ctx.pageSize
+
+      if end > len(items) {
+        end = len(items)
+      }
+
+      page.Items = items[start:end]
+    }
+
+    if page.PageNum > 1 {
+      page.PrevPage = ctx.pageURL(page.PageNum - 1)
+    }
+
+    if page.PageNum < totalPages {
+      page.NextPage = ctx.pageURL(page.PageNum + 1)
+      page.HiddenItemCount = 0
+    }
+
+    result[i] = page
+  }
+
+  return result
+}
+
+// removeStalePages removes the pages after the last one, which are left over
+// from a run when the listing was longer. Pages are numbered without gaps, so
+// the first one that's missing is where they end.
+func (ctx *dirContext) removeStalePages(totalPages int) error {
+  for num := totalPages + 1; ; num++ {
+    path := ctx.pagePath(num)
+
+    if _, err := os.Lstat(path); errors.Is(err, fs.ErrNotExist) {
+      return nil
+    }
+
+    err := ctx.removeGenerated(path, ctx.isGeneratedContent)
+
+    if err == nil && ctx.gzip {
+      gzipName := ctx.pageName(num) + gzipSuffix
+      isGenerated, _ := ctx.outputCheck(ctx.dirAbsolute, gzipName, false)
+      err = ctx.removeGenerated(path + gzipSuffix, isGenerated)
+    }
+
+    if err != nil {
+      return err
+    }
+  }
+}
+
+// pageName returns the file name of the given page: the first page is the
+// index itself and the rest are numbered, e.g. index-2.html.
+func (runner *RootCmdRunner) pageName(num int) string {
+  name := runner.renderTargetName()
+
+  if num == 1 {
+    return name
+  }
+
+  ext := filepath.Ext(name)
+  return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), num, ext)
+}
+
+func (ctx *dirContext) pagePath(num int) string {
+  return filepath.Join(ctx.outputDir(), ctx.pageName(num))
+}
+
+func (ctx *dirContext) pageURL(num int) string {
+  if !ctx.absoluteLinks() {
+    return relativeURL(ctx.pageName(num))
+  }
+
+  return ctx.absoluteURL(path.Join(ctx.dirChrooted, ctx.pageName(num)))
+}
+
+// isPageName reports whether name looks like one of the numbered pages, so
+// that pages from a previous run don't end up in the listing.
+func (runner *RootCmdRunner) isPageName(name string) bool {
+  return runner.pageSize != 0 && isNumberedPage(runner.renderTargetName(), name)
+}
+
+// isNumberedPage reports whether name is one of the numbered pages of the
+// index called target, such as index-2.html for index.html.
+func isNumberedPage(target string, name string) bool {
+  ext := filepath.Ext(target)
+  prefix := strings.TrimSuffix(target, ext) + "-"
+
+  if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+    return false
+  }
+
+  num := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext)
+  n, err := strconv.Atoi(num)
+  return err == nil && n > 1
+}
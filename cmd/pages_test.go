@@ -0,0 +1,37 @@
+package cmd
+
+import (
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+func TestShrunkListingRemovesStalePages(t *testing.T) {
+  root := t.TempDir()
+  writeTree(t, root, map[string]string{"a": "", "b": "", "c": "", "d": ""})
+
+  args := []string{"-q", "--root", root, "--page-size", "1", "--gzip", root}
+
+  if err := runIndexify(t, args...); err != nil {
+    t.Fatal(err)
+  }
+
+  for _, name := range []string{"b", "c", "d"} {
+    if err := os.Remove(filepath.Join(root, name)); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  // a hand-written file that happens to have the name of a page stays
+  writeTree(t, root, map[string]string{"index-3.html": "hand-written"})
+
+  if err := runIndexify(t, args...); err != nil {
+    t.Fatal(err)
+  }
+
+  want := []string{"a", "index-3.html", "index.html", "index.html.gz"}
+
+  if got := listFiles(t, root); !equalStrings(got, want) {
+    t.Errorf("expected %v, got %v", want, got)
+  }
+}
@@ -0,0 +1,39 @@
+package cmd
+
+import (
+  "strings"
+)
+
+// textMimeTypes are the types besides text/* that --preview shows as text.
+var textMimeTypes = []string{
+  "application/json",
+  "application/javascript",
+  "application/xml",
+  "application/x-sh",
+  "application/toml",
+  "application/yaml",
+}
+
+// PreviewKind returns how --preview shows the item: "image" in an overlay,
+// "text" fetched into a <pre>, or "" if it isn't previewed at all.
+func (di DirectoryItem) PreviewKind() string {
+  if di.IsDir || di.SymlinkBroken {
+    return ""
+  }
+
+  if strings.HasPrefix(di.MimeType, "image/") {
+    return "image"
+  }
+
+  if strings.HasPrefix(di.MimeType, "text/") {
+    return "text"
+  }
+
+  for _, mimeType := range textMimeTypes {
+    if strings.HasPrefix(di.MimeType, mimeType) {
+      return "text"
+    }
+  }
+
+  return ""
+}
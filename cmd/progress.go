@@ -0,0 +1,151 @@
+package cmd
+
+import (
+  "fmt"
+  "os"
+  "sync"
+  "time"
+)
+
+const (
+  // how often the line is redrawn on a terminal
+  progressTTYInterval = 100 * time.Millisecond
+
+  // how often a line is printed when stderr isn't a terminal, so that logs
+  // don't fill up with progress
+  progressPlainInterval = 5 * time.Second
+)
+
+// progress reports how many directories have been processed on stderr, for
+// --progress. On a terminal, a single line is updated in place.
+type progress struct {
+  mu sync.Mutex
+  tty bool
+  start time.Time
+  last time.Time
+  done int
+
+  // only known when the directories are collected up front, as with --jobs
+  total int
+
+  // whether the line on the terminal needs to be cleared before printing
+  // anything else
+  drawn bool
+}
+
+func newProgress() *progress {
+  return &progress{
+    tty: isTerminal(os.Stderr),
+    start: time.Now(),
+  }
+}
+
+func isTerminal(f *os.File) bool {
+  info, err := f.Stat()
+  return err == nil && info.Mode() & os.ModeCharDevice != 0
+}
+
+func (p *progress) setTotal(total int) {
+  if p == nil {
+    return
+  }
+
+  p.mu.Lock()
+  defer p.mu.Unlock()
+
+  p.total = total
+}
+
+// add counts a processed directory and prints the progress if enough time has
+// passed since it was last printed.
+func (p *progress) add() {
+  if p == nil {
+    return
+  }
+
+  p.mu.Lock()
+  defer p.mu.Unlock()
+
+  p.done += 1
+  interval := progressPlainInterval
+
+  if p.tty {
+    interval = progressTTYInterval
+  }
+
+  if time.Since(p.last) < interval {
+    return
+  }
+
+  p.last = time.Now()
+  p.print()
+}
+
+// finish prints the final count and ends the line on a terminal.
+func (p *progress) finish() {
+  if p == nil {
+    return
+  }
+
+  p.mu.Lock()
+  defer p.mu.Unlock()
+
+  p.print()
+
+  if p.tty {
+    fmt.Fprintln(os.Stderr)
+    p.drawn = false
+  }
+}
+
+// interrupt clears the progress line on a terminal for the duration of print,
+// so that other output doesn't end up in the middle of it.
+func (p *progress) interrupt(print func()) {
+  if p == nil {
+    print()
+    return
+  }
+
+  p.mu.Lock()
+  defer p.mu.Unlock()
+
+  if !p.drawn {
+    print()
+    return
+  }
+
+  fmt.Fprint(os.Stderr, "\r\033[K")
+  print()
+  p.print()
+}
+
+func (p *progress) print() {
+  line := p.line()
+
+  if !p.tty {
+    fmt.Fprintln(os.Stderr, line)
+    return
+  }
+
+  fmt.Fprint(os.Stderr, "\r\033[K" + line)
+  p.drawn = true
+}
+
+func (p *progress) line() string {
+  elapsed := time.Since(p.start)
+
+  if p.total == 0 {
+    return fmt.Sprintf(
+      "%d directories processed in %s", p.done, elapsed.Round(time.Second),
+    )
+  }
+
+  line := fmt.Sprintf("%d/%d directories processed", p.done, p.total)
+
+  if p.done > 0 && p.done < p.total {
+    left := elapsed / time.Duration(p.done) * time.Duration(p.total - p.done)
+    line += fmt.Sprintf(", about %s left", left.Round(time.Second))
+  }
+
+  return line
+}
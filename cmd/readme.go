@@ -0,0 +1,59 @@
+package cmd
+
+import (
+  "bytes"
+  "errors"
+  "html/template"
+  "io/fs"
+  "strings"
+
+  "github.com/yuin/goldmark"
+)
+
+// readmeNames are the files --readme looks for, in order of preference.
+var readmeNames = []string{"README.md", "README.txt"}
+
+// readReadme returns the current directory's README as html, or an empty
+// string if it doesn't have one. A README that the listing leaves out, such
+// as one matching the .indexignore patterns in ignored, isn't shown either.
+// Otherwise it stays in the listing as well.
+func (ctx *dirContext) readReadme(ignored []string) (template.HTML, error) {
+  for _, name := range readmeNames {
+    if ctx.isIgnored(ctx.dirAbsolute, name, false, ignored) {
+      continue
+    }
+
+    data, err := fs.ReadFile(ctx.fsys, ctx.itemPath(name))
+
+    if errors.Is(err, fs.ErrNotExist) {
+      continue
+    }
+
+    if err != nil {
+      return "", err
+    }
+
+    if ctx.readmeMarkdown && strings.HasSuffix(name, ".md") {
+      return renderMarkdown(data)
+    }
+
+    return template.HTML(
+      "<pre>" + template.HTMLEscapeString(string(data)) + "</pre>",
+    ), nil
+  }
+
+  return "", nil
+}
+
+// renderMarkdown converts markdown to html. goldmark leaves out raw html and
+// links with dangerous schemes such as javascript: unless told otherwise,
+// so the result is safe to include in the page as is.
+func renderMarkdown(data []byte) (template.HTML, error) {
+  var buf bytes.Buffer
+
+  if err := goldmark.Convert(data, &buf); err != nil {
+    return "", err
+  }
+
+  return template.HTML(buf.String()), nil
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+  "testing"
+)
+
+func TestReadmeFollowsListingFilters(t *testing.T) {
+  tests := []struct {
+    name string
+    files map[string]string
+    args []string
+    shown bool
+  }{
+    {"listed", map[string]string{}, nil, true},
+    {"excluded", map[string]string{}, []string{"--exclude", "README*"}, false},
+    {"indexignore", map[string]string{".indexignore": "README.md\n"}, nil, false},
+    {"gitignore", map[string]string{".gitignore": "README.md\n"}, []string{"--gitignore"}, false},
+    {"gitignore not set", map[string]string{".gitignore": "README.md\n"}, nil, true},
+  }
+
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      root := t.TempDir()
+      tt.files["README.md"] = "hello"
+      writeTree(t, root, tt.files)
+
+      args := append([]string{"-q", "--root", root, "--format", "json", "--readme"}, tt.args...)
+
+      if err := runIndexify(t, append(args, root)...); err != nil {
+        t.Fatal(err)
+      }
+
+      readme := readListing(t, root).Readme
+
+      if shown := readme != ""; shown != tt.shown {
+        t.Errorf("expected the readme to be shown: %v, got %q", tt.shown, readme)
+      }
+    })
+  }
+}
@@ -3,306 +3,2895 @@ package cmd
 import (
   "bytes"
   "embed"
+  "encoding/json"
   "errors"
   "fmt"
+  "io"
   "io/fs"
+  "mime"
+  "net/http"
   "net/url"
   "os"
+  "path"
   "path/filepath"
+  "strconv"
   "strings"
-  "text/template"
+  "sync"
+  "html/template"
+  texttemplate "text/template"
   "time"
 
   "github.com/dustin/go-humanize"
   "github.com/spf13/cobra"
+  "golang.org/x/text/language"
 )
 
-//go:embed template.html
+//go:embed template.html template-grid.html template-common.html summary.html
 var embedded embed.FS
 
+// themeTemplates maps the --theme-name values to the embedded templates. All
+// of them get the same IndexTemplate.
+var themeTemplates = map[string]string{
+  "table": "template.html",
+  "grid": "template-grid.html",
+}
+
+// commonTemplateName is parsed along with each of the themeTemplates, for the
+// parts of the page they share.
+const commonTemplateName = "template-common.html"
+
 var errTargetIsADirectory = errors.New("target is a directory")
 var errTargetExistsAndIsNotGenerated = errors.New("target already exists and is not a generated file")
 
 type RootCmdRunner struct {
+  configPath string
+  quiet bool
+  verbose bool
+  quietSkips bool
   dryRun bool
+  recursive bool
+  keepGoing bool
+  skipRoot bool
+  followSymlinks bool
+  maxDepth int
   includeHidden bool
+  excludes []string
+  excludePaths []string
+  sets []string
+  extra map[string]string
+  includeExts []string
+  onlyDirs bool
+  minSize string
+  maxSize string
+  minBytes uint64
+  maxBytes uint64
+  gitignore bool
+  computeDirSizes bool
+  sizeUnits string
+  countChildren bool
+  showPerms bool
+  noSniff bool
+  checksums string
+  hashJobs int
+  checksumVerify bool
+  thumbnails bool
+  pageSize int
+  maxItems int
+  skipEmpty bool
+  backup bool
+  theme string
+  themeName string
+  css string
+  minify bool
+  stream bool
+  dateFormat string
+  localizeDates bool
+  timezone string
+  relativeTime bool
+  reproducible bool
+  sourceDate time.Time
+  gitTimes bool
+  search bool
+  noRobots bool
+  readme bool
+  readmeMarkdown bool
+  location *time.Location
+  extraCSS template.CSS
+  stylesheetURL string
+  headerFile string
+  footerFile string
+  headerHTML template.HTML
+  footerHTML template.HTML
   stdout bool
+  outDir string
+  watch bool
+  baseUrl string
+  absoluteURLs bool
+  sitemap bool
+  summaryPage bool
+  twoPass bool
+  showProgress bool
+  gzip bool
+  skipUnchanged bool
+  preview bool
+  logJSON bool
+  noSize bool
+  noDate bool
+  noTypeIcon bool
+  noUpLink bool
+  upLabel string
+  ogTags bool
+  ogImage string
+  since string
+  sinceTime time.Time
+  collate string
+  collateTag language.Tag
+  showIndex bool
   indexName string
-
-  dirRelative string
-  dirAbsolute string
+  templatePath string
+  tmpl *template.Template
+  title string
+  titleTmpl *texttemplate.Template
+  marker string
+  format string
+  rss bool
+  rssLimit int
+  sortBy string
+  reverse bool
+  dirsFirst bool
+  groupBy string
+
+  jobs int
+
+  startDirs []string
+  state *runState
 
   rootRelative string
   rootAbsolute string
 
+  // what fetchData reads the directories from, os.DirFS(rootAbsolute) unless
+  // set beforehand. Symlink targets, git times and .gitignore files are still
+  // read from disk.
+  fsys fs.FS
+}
+
+// dirContext is the state of indexing a single directory. prepare creates a
+// new one for every directory, so that the runner, with the flags, stays the
+// same throughout the run and directories can be processed concurrently.
+type dirContext struct {
+  *RootCmdRunner
+
+  dirRelative string
+  dirAbsolute string
   dirRelativeToRoot string
   dirChrooted string
+
+  // the directory's path in fsys
+  dirInFS string
+
   templateData IndexTemplate
+
+  // with --stream, the entries to list instead of templateData.Items
+  streamed *streamedEntries
+
+  // with --two-pass, the absolute paths of the directories that get a listing
+  // of their own. completeItem leaves their counts and sizes to
+  // useChildListings rather than reading them separately.
+  listedDirs map[string]bool
+}
+
+// runState is shared by every directory of a run, including the ones that
+// are processed in parallel with --jobs.
+type runState struct {
+  mu sync.Mutex
+  sitemapEntries map[string]sitemapEntry
+  gitignoreCache map[string]*gitignoreFile
+  stats runStats
+  progress *progress
+
+  // the listings of a --stdout --format json run over several directories,
+  // written out as one array at the end
+  stdoutListings []jsonIndex
+
+  // whether the advice on hand-written files has been given already
+  hintedHandWritten bool
+}
+
+func newRunState() *runState {
+  return &runState{
+    sitemapEntries: map[string]sitemapEntry{},
+    gitignoreCache: map[string]*gitignoreFile{},
+  }
 }
 
 type IndexTemplate struct {
-  Name string
-  Breadcrumbs []Breadcrumb
-  NumDirs int
-  NumFiles int
-  CanGoUp bool
-  Items []DirectoryItem
+  Name string `json:"name"`
+  Theme string `json:"-"`
+  ExtraCSS template.CSS `json:"-"`
+  StylesheetURL string `json:"-"`
+  HeaderHTML template.HTML `json:"-"`
+  FooterHTML template.HTML `json:"-"`
+  DateFormat string `json:"-"`
+  LocalizeDates bool `json:"-"`
+  RelativeTime bool `json:"-"`
+  Search bool `json:"-"`
+  SizeUnits string `json:"-"`
+  ShowPerms bool `json:"-"`
+  NoIndex bool `json:"-"`
+  Preview bool `json:"-"`
+  HideSize bool `json:"-"`
+  HideDate bool `json:"-"`
+  HideIcons bool `json:"-"`
+  UpLabel string `json:"-"`
+
+  // Open Graph tags for link previews, with --og-tags
+  OGTags bool `json:"-"`
+  OGImage string `json:"-"`
+  OGURL string `json:"-"`
+  Breadcrumbs []Breadcrumb `json:"breadcrumbs"`
+  NumDirs int `json:"numDirs"`
+  NumFiles int `json:"numFiles"`
+  NumItems int `json:"numItems"`
+  TotalSize int64 `json:"totalSize"`
+  ComputedDirSizes bool `json:"computedDirSizes"`
+  CountedChildren bool `json:"countedChildren"`
+  PageNum int `json:"pageNum"`
+  TotalPages int `json:"totalPages"`
+  PrevPage string `json:"prevPage,omitempty"`
+  NextPage string `json:"nextPage,omitempty"`
+  HiddenItemCount int `json:"hiddenItemCount,omitempty"`
+  CanGoUp bool `json:"canGoUp"`
+  ParentURL string `json:"parentUrl,omitempty"`
+  Readme template.HTML `json:"readme,omitempty"`
+  Items []DirectoryItem `json:"items"`
+  Groups []ItemGroup `json:"groups,omitempty"`
+
+  // values from --set, for custom templates
+  Extra map[string]string `json:"extra,omitempty"`
+}
+
+type Breadcrumb struct {
+  Text string `json:"text"`
+  Link string `json:"link"`
+
+  // the directory of the page itself, shown as plain text. The root is
+  // always a link, even on its own page.
+  Current bool `json:"current,omitempty"`
+}
+
+type DirectoryItem struct {
+  URL string `json:"url"`
+  IsDir bool `json:"isDir"`
+  IsSymlink bool `json:"isSymlink"`
+  Name string `json:"name"`
+  Size int64 `json:"size"`
+  ModTime time.Time `json:"modTime"`
+  Mode string `json:"mode"`
+  SymlinkTarget string `json:"symlinkTarget,omitempty"`
+  SymlinkBroken bool `json:"symlinkBroken,omitempty"`
+  ThumbURL string `json:"thumbUrl,omitempty"`
+  MimeType string `json:"mimeType,omitempty"`
+  ChildCount int `json:"childCount,omitempty"`
+  Checksum string `json:"checksum,omitempty"`
+  SizeUnits string `json:"-"`
+  sizeComputed bool
+
+  // set when the file couldn't be hashed for --checksums
+  checksumFailed bool
+}
+
+// jsonIndex is what gets written with --format json. The generator field
+// doubles as the marker checkRenderTarget looks for before overwriting.
+type jsonIndex struct {
+  Generator string `json:"generator"`
+  IndexTemplate
+}
+
+const generatorName = "indexify"
+
+// builtinMarker is what the built-in templates write in their footer, and the
+// default --marker.
+const builtinMarker = "Index generated with"
+const backupSuffix = ".bak"
+
+var rootCmdRunner = RootCmdRunner{}
+var rootCmd = &cobra.Command{
+  Use: "indexify [dir]...",
+  Version: "1.0.0",
+  RunE: rootCmdRunner.Run,
+  Args: cobra.ArbitraryArgs,
+  PersistentPreRunE: loadConfig,
+}
+
+// The exit codes of a run. Automation can tell a run that found nothing to
+// do, such as a --skip-unchanged run over an up to date tree, from one that
+// wrote something.
+const (
+  exitOK = 0
+  exitError = 1
+  exitNothingWritten = 2
+)
+
+func Execute() {
+  os.Exit(execute())
+}
+
+// execute runs the command and returns the exit code it ends with.
+func execute() int {
+  if err := rootCmd.Execute(); err != nil {
+    fmt.Fprintln(rootCmd.OutOrStdout(), err)
+    return exitError
+  }
+
+  return rootCmdRunner.exitCode()
+}
+
+// exitCode returns the exit code of a run that succeeded. Other commands,
+// --help and --checksum-verify never write anything, so they always exit with
+// exitOK.
+func (runner *RootCmdRunner) exitCode() int {
+  if runner.state == nil || runner.checksumVerify {
+    return exitOK
+  }
+
+  if runner.state.stats.written == 0 {
+    return exitNothingWritten
+  }
+
+  return exitOK
+}
+
+func init() {
+  rootCmd.PersistentFlags().StringVarP(
+    &rootCmdRunner.configPath,
+    "config", "", "",
+    "path to a config file with flag defaults (default \"indexify.yaml\" if present)",
+  )
+
+  rootCmd.PersistentFlags().BoolVarP(
+    &rootCmdRunner.quiet,
+    "quiet", "q", false,
+    "only print errors",
+  )
+
+  rootCmd.PersistentFlags().BoolVarP(
+    &rootCmdRunner.logJSON,
+    "log-json", "", false,
+    "print status output as one JSON object per line on stderr",
+  )
+
+  rootCmd.PersistentFlags().BoolVarP(
+    &rootCmdRunner.verbose,
+    "verbose", "", false,
+    "print every file written, every skip and per-directory counts",
+  )
+
+  rootCmd.PersistentFlags().BoolVarP(
+    &rootCmdRunner.quietSkips,
+    "quiet-skips", "", false,
+    "only print skipped hand-written files with --verbose",
+  )
+
+  rootCmd.MarkFlagsMutuallyExclusive("quiet", "verbose")
+
+  rootCmd.PersistentFlags().StringVarP(
+    &rootCmdRunner.rootRelative,
+    "root", "", "",
+    "path to root directory",
+  )
+
+  rootCmd.PersistentFlags().BoolVarP(
+    &rootCmdRunner.recursive,
+    "recursive", "r", false,
+    "also index all subdirectories",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.keepGoing,
+    "keep-going", "k", false,
+    "report errors in a directory and carry on with the rest",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.skipRoot,
+    "skip-root", "", false,
+    "don't index dir itself, only the directories below it",
+  )
+
+  rootCmd.PersistentFlags().BoolVarP(
+    &rootCmdRunner.followSymlinks,
+    "follow-symlinks", "", false,
+    "also descend into symlinked directories when recursive",
+  )
+
+  rootCmd.PersistentFlags().IntVarP(
+    &rootCmdRunner.maxDepth,
+    "max-depth", "", -1,
+    "how many levels below dir to descend when recursive (-1 means no limit)",
+  )
+
+  rootCmd.Flags().StringVarP(
+    &rootCmdRunner.baseUrl,
+    "base-url", "", "",
+    "url of the root directory, used to build absolute links",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.absoluteURLs,
+    "absolute-urls", "", false,
+    "make links absolute paths from the root directory, even without --base-url",
+  )
+
+  rootCmd.Flags().IntVarP(
+    &rootCmdRunner.jobs,
+    "jobs", "j", 1,
+    "number of directories to process in parallel",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.sitemap,
+    "sitemap", "", false,
+    "write a sitemap.xml of all generated indexes to the root directory",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.summaryPage,
+    "summary-page", "", false,
+    "write a summary.html with totals for the whole tree to the root directory",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.showProgress,
+    "progress", "", false,
+    "show how many directories have been processed on stderr",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.gzip,
+    "gzip", "", false,
+    "also write a gzip-compressed copy of each index, such as index.html.gz",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.skipUnchanged,
+    "skip-unchanged", "", false,
+    "don't rewrite files whose contents would stay the same",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.includeHidden,
+    "hidden", "", false,
+    "index hidden files",
+  )
+
+  rootCmd.Flags().StringArrayVarP(
+    &rootCmdRunner.excludes,
+    "exclude", "", nil,
+    "skip entries whose name matches the glob pattern (repeatable)",
+  )
+
+  rootCmd.Flags().StringArrayVarP(
+    &rootCmdRunner.excludePaths,
+    "exclude-path", "", nil,
+    "don't index the directory at this path or anything below it (repeatable)",
+  )
+
+  rootCmd.Flags().StringArrayVarP(
+    &rootCmdRunner.includeExts,
+    "include-ext", "", nil,
+    "only list files with this extension, such as .zip or .tar.gz (repeatable)",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.onlyDirs,
+    "only-dirs", "", false,
+    "only list directories, for navigation-only indexes",
+  )
+
+  rootCmd.Flags().StringVarP(
+    &rootCmdRunner.since,
+    "since", "", "",
+    "only list files modified within this duration, such as 168h, or since a date such as 2024-01-31",
+  )
+
+  rootCmd.Flags().StringVarP(
+    &rootCmdRunner.minSize,
+    "min-size", "", "",
+    "only list files of at least this size, such as 10MiB",
+  )
+
+  rootCmd.Flags().StringVarP(
+    &rootCmdRunner.maxSize,
+    "max-size", "", "",
+    "only list files of at most this size, such as 1GB",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.gitignore,
+    "gitignore", "", false,
+    "skip entries ignored by .gitignore files",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.computeDirSizes,
+    "compute-dir-sizes", "", false,
+    "show the total size of each subdirectory (slow on large trees)",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.countChildren,
+    "count-children", "", false,
+    "show how many entries each subdirectory has",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.twoPass,
+    "two-pass", "", false,
+    "read the whole tree before writing, so subdirectory counts and sizes match their own indexes",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.showPerms,
+    "show-perms", "", false,
+    "add a column with each entry's permission bits",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.noSize,
+    "no-size", "", false,
+    "leave out the size column",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.noDate,
+    "no-date", "", false,
+    "leave out the modification time column",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.noTypeIcon,
+    "no-type-icon", "", false,
+    "leave out the folder and file icons",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.noUpLink,
+    "no-up-link", "", false,
+    "leave out the link to the parent directory",
+  )
+
+  rootCmd.Flags().StringVarP(
+    &rootCmdRunner.upLabel,
+    "up-label", "", "Go up",
+    "text of the link to the parent directory",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.preview,
+    "preview", "", false,
+    "open images and text files in an overlay instead of a new page (needs javascript)",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.showIndex,
+    "show-index", "", false,
+    "list the index file itself, as it was before this run",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.noSniff,
+    "no-sniff", "", false,
+    "don't read files to detect their type when the extension is unknown",
+  )
+
+  rootCmd.Flags().StringVarP(
+    &rootCmdRunner.checksums,
+    "checksums", "", "",
+    "hash files with md5, sha1 or sha256 and write a checksum file",
+  )
+
+  rootCmd.Flags().IntVarP(
+    &rootCmdRunner.hashJobs,
+    "hash-jobs", "", 0,
+    "number of files to hash in parallel with --checksums (0 means one per cpu)",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.checksumVerify,
+    "checksum-verify", "", false,
+    "compare files with the existing checksum files instead of writing anything",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.thumbnails,
+    "thumbnails", "", false,
+    "show thumbnails of jpeg, png and gif images, kept in a .thumbs directory",
+  )
+
+  rootCmd.Flags().IntVarP(
+    &rootCmdRunner.pageSize,
+    "page-size", "", 0,
+    "split listings into pages of this many items (0 means no limit)",
+  )
+
+  rootCmd.Flags().IntVarP(
+    &rootCmdRunner.maxItems,
+    "max-items", "", 0,
+    "only list the first N items and how many more there are (0 means no limit)",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.skipEmpty,
+    "skip-empty", "", false,
+    "don't generate indexes for empty directories",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.backup,
+    "backup", "", false,
+    "keep the previous version of a regenerated file as .bak",
+  )
+
+  rootCmd.Flags().StringVarP(
+    &rootCmdRunner.theme,
+    "theme", "", "auto",
+    "color theme, light, dark or auto to follow the browser",
+  )
+
+  rootCmd.Flags().StringVarP(
+    &rootCmdRunner.themeName,
+    "theme-name", "", "table",
+    "layout of the built-in template, table or grid",
+  )
+
+  rootCmd.Flags().StringVarP(
+    &rootCmdRunner.sizeUnits,
+    "size-units", "", "iec",
+    "units for file sizes, iec for KiB (1024 bytes) or si for kB (1000 bytes)",
+  )
+
+  rootCmd.Flags().StringVarP(
+    &rootCmdRunner.css,
+    "css", "", "",
+    "extra css to add to the page: inline css, a local file or a stylesheet url",
+  )
+
+  rootCmd.Flags().StringVarP(
+    &rootCmdRunner.headerFile,
+    "header-file", "", "",
+    "file with html to add to the top of every page",
+  )
+
+  rootCmd.Flags().StringVarP(
+    &rootCmdRunner.footerFile,
+    "footer-file", "", "",
+    "file with html to add to the bottom of every page",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.minify,
+    "minify", "", false,
+    "strip comments and collapse whitespace in the generated html",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.stream,
+    "stream", "", false,
+    "write the listing while reading it, for directories with millions of entries",
+  )
+
+  rootCmd.Flags().StringVarP(
+    &rootCmdRunner.dateFormat,
+    "date-format", "", "",
+    "go time layout for modification times, or iso, rfc822, rfc1123 or date",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.relativeTime,
+    "relative-time", "", false,
+    "show modification times relative to now, such as \"3 days ago\"",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.gitTimes,
+    "git-times", "", false,
+    "use the date of the last git commit as the modification time of tracked files",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.reproducible,
+    "reproducible", "", false,
+    "produce the same output for the same files, with times limited to SOURCE_DATE_EPOCH",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.readme,
+    "readme", "", false,
+    "show the directory's README.md or README.txt above the listing",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.readmeMarkdown,
+    "readme-markdown", "", false,
+    "render a README.md shown by --readme as markdown",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.search,
+    "search", "", true,
+    "add a box for filtering the listing by name (needs javascript)",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.ogTags,
+    "og-tags", "", false,
+    "add Open Graph tags with the title and counts, for link previews in chat apps",
+  )
+
+  rootCmd.Flags().StringVarP(
+    &rootCmdRunner.ogImage,
+    "og-image", "", "",
+    "url of an image to show in link previews, implies --og-tags",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.noRobots,
+    "no-robots", "", false,
+    "ask search engines not to index the listings or follow their links",
+  )
+
+  rootCmd.Flags().StringVarP(
+    &rootCmdRunner.timezone,
+    "timezone", "", "UTC",
+    "time zone for modification times, an IANA name such as Europe/Helsinki or local",
+  )
+
+  rootCmd.PersistentFlags().BoolVarP(
+    &rootCmdRunner.dryRun,
+    "dry-run", "n", false,
+    "don't write anything to disk",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.stdout,
+    "stdout", "", false,
+    "output to stdout only",
+  )
+
+  rootCmd.PersistentFlags().StringVarP(
+    &rootCmdRunner.outDir,
+    "out-dir", "", "",
+    "write the generated files to this directory instead, mirroring the tree below root",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.watch,
+    "watch", "", false,
+    "keep running and regenerate indexes when files change",
+  )
+
+  rootCmd.PersistentFlags().StringVarP(
+    &rootCmdRunner.indexName,
+    "index-name", "", "index.html",
+    "name of index file to generate",
+  )
+
+  rootCmd.Flags().StringVarP(
+    &rootCmdRunner.templatePath,
+    "template", "", "",
+    "path to a custom template to use instead of the built-in one",
+  )
+
+  rootCmd.Flags().StringArrayVarP(
+    &rootCmdRunner.sets,
+    "set", "", nil,
+    "make key=value available to templates as {{index .Extra \"key\"}} (repeatable)",
+  )
+
+  rootCmd.Flags().StringVarP(
+    &rootCmdRunner.title,
+    "title", "", "Index: {{.Dir}}",
+    "go template for the page title, with .Dir, .Name, .NumDirs and .NumFiles",
+  )
+
+  rootCmd.PersistentFlags().StringVarP(
+    &rootCmdRunner.marker,
+    "marker", "", builtinMarker,
+    "text that identifies a generated index as safe to overwrite",
+  )
+
+  rootCmd.PersistentFlags().StringVarP(
+    &rootCmdRunner.format,
+    "format", "", "html",
+    "output format, html or json",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.rss,
+    "rss", "", false,
+    "also write an RSS feed of the most recently modified files",
+  )
+
+  rootCmd.Flags().IntVarP(
+    &rootCmdRunner.rssLimit,
+    "rss-limit", "", 20,
+    "maximum number of items in the RSS feed",
+  )
+
+  rootCmd.Flags().StringVarP(
+    &rootCmdRunner.sortBy,
+    "sort", "", "name",
+    "sort items by name, natural, size, date or type",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.reverse,
+    "reverse", "", false,
+    "reverse the order of the active sort key",
+  )
+
+  rootCmd.Flags().StringVarP(
+    &rootCmdRunner.collate,
+    "collate", "", "",
+    "compare names by the rules of a language, such as en or fi, so accented letters sort naturally",
+  )
+
+  rootCmd.Flags().BoolVarP(
+    &rootCmdRunner.dirsFirst,
+    "dirs-first", "", false,
+    "list directories before files",
+  )
+
+  rootCmd.Flags().StringVarP(
+    &rootCmdRunner.groupBy,
+    "group-by", "", "",
+    "split the listing into sections, currently only by type",
+  )
+}
+
+func (runner *RootCmdRunner) Run(cmd *cobra.Command, args []string) error {
+  var err error
+
+  err = runner.parseArgs(args)
+
+  if err != nil {
+    return err
+  }
+
+  // the arguments are fine, so whatever goes wrong from here on, such as a
+  // file that differs from its checksum, isn't helped by the usage
+  cmd.SilenceUsage = true
+
+  start := time.Now()
+  runner.state = newRunState()
+
+  if runner.checksumVerify {
+    return runner.verifyChecksums()
+  }
+
+  if runner.showProgress {
+    runner.state.progress = newProgress()
+  }
+
+  switch {
+  case runner.twoPass:
+    err = runner.processTwoPass()
+
+  case runner.jobs > 1:
+    err = runner.processInParallel()
+
+  default:
+    err = runner.walkStartDirs(runner.processDirectory)
+  }
+
+  // directories regenerated by --watch aren't counted
+  runner.state.progress.finish()
+  runner.state.progress = nil
+
+  if err == nil && runner.collectsStdout() {
+    err = runner.writeStdoutListings()
+  }
+
+  if err == nil && runner.sitemap {
+    err = runner.renderSitemap()
+  }
+
+  if err == nil && runner.summaryPage {
+    err = runner.renderSummaryPage()
+  }
+
+  if err != nil {
+    runner.countError()
+  }
+
+  // with --stdout, the summary would end up in the middle of the output
+  if (runner.recursive || len(runner.startDirs) > 1) && !runner.stdout {
+    runner.printSummary(time.Since(start))
+  }
+
+  if err == nil && runner.watch {
+    err = runner.watchForChanges()
+  }
+
+  // with --keep-going, the errors have been reported already but the run
+  // still failed
+  if err == nil && runner.state.stats.errors > 0 {
+    err = fmt.Errorf(
+      "some directories could not be indexed (%d errors)",
+      runner.state.stats.errors,
+    )
+  }
+
+  return err
+}
+
+// defaultDirs returns the directories given on the command line, or the
+// current directory if there are none.
+func defaultDirs(args []string) []string {
+  if len(args) == 0 {
+    return []string{"."}
+  }
+
+  return args
+}
+
+// walkStartDirs walks each of the directories given on the command line in
+// turn, with the counts of all of them going into the same summary.
+func (runner *RootCmdRunner) walkStartDirs(visit func(string) error) error {
+  // a directory that is given twice, or is below another one, is only
+  // visited once
+  visited := visitedDirs{}
+
+  for _, dir := range runner.startDirs {
+    err := runner.walk(dir, visited, visit)
+
+    if err != nil {
+      return err
+    }
+  }
+
+  return nil
+}
+
+// isStartDir reports whether dir is one of the directories given on the
+// command line.
+func (runner *RootCmdRunner) isStartDir(dir string) bool {
+  for _, start := range runner.startDirs {
+    if filepath.Clean(dir) == filepath.Clean(start) {
+      return true
+    }
+  }
+
+  return false
+}
+
+// startDirOf returns the directory given on the command line that path is
+// in, for measuring its depth.
+func (runner *RootCmdRunner) startDirOf(path string) string {
+  for _, start := range runner.startDirs {
+    rel, err := filepath.Rel(start, path)
+
+    if err == nil && rel != ".." && !strings.HasPrefix(rel, ".." + string(filepath.Separator)) {
+      return start
+    }
+  }
+
+  return runner.startDirs[0]
+}
+
+// walk calls visit for dir and, in recursive mode, every directory below it
+// that isn't excluded, leaving out those in visited.
+func (runner *RootCmdRunner) walk(
+  dir string,
+  visited visitedDirs,
+  visit func(string) error,
+) error {
+  if runner.keepGoing {
+    visit = runner.continueOnError(visit)
+  }
+
+  if !runner.recursive {
+    if !visited.add(dir) {
+      return nil
+    }
+
+    return visit(dir)
+  }
+
+  return runner.walkTree(dir, dir, visited, visit)
+}
+
+// walkTree walks the tree at dir, which is start or somewhere below it. With
+// --follow-symlinks, symlinks to directories are walked as well, but each
+// directory is only visited once, even when several links lead to it.
+func (runner *RootCmdRunner) walkTree(
+  start string,
+  dir string,
+  visited visitedDirs,
+  visit func(string) error,
+) error {
+  walkDir := func(path string, d fs.DirEntry, err error) error {
+    // a directory that can't be read has already been passed to visit, which
+    // ran into the same error and reported it with --keep-going
+    if err != nil && runner.keepGoing && d != nil {
+      return nil
+    }
+
+    if err != nil {
+      return err
+    }
+
+    isLink := d.Type() & fs.ModeSymlink != 0
+
+    if !d.IsDir() && !(isLink && runner.followSymlinks) {
+      return nil
+    }
+
+    if runner.maxDepth >= 0 && walkDepth(start, path) > runner.maxDepth {
+      return filepath.SkipDir
+    }
+
+    // the directory the walk starts from was asked for explicitly, so it's
+    // always visited, even when its own name is hidden or excluded. The
+    // filters only apply to what's below it.
+    if path != dir {
+      err = runner.checkWalkFilters(path, d.Name())
+
+      if errors.Is(err, errSkipWalk) {
+        return filepath.SkipDir
+      }
+
+      if err != nil {
+        return err
+      }
+    }
+
+    // walkSubtree goes on to the link's target once it's done with the rest
+    if isLink {
+      return nil
+    }
+
+    return visit(path)
+  }
+
+  return walkSubtree(dir, runner.followSymlinks, visited, walkDir)
+}
+
+// errSkipWalk is returned by checkWalkFilters for a directory that the walk
+// should leave out.
+var errSkipWalk = errors.New("skip directory")
+
+// checkWalkFilters decides whether the walk descends into the directory at
+// path, returning errSkipWalk if it doesn't.
+func (runner *RootCmdRunner) checkWalkFilters(path string, name string) error {
+  // hidden entries aren't listed, so their contents shouldn't be indexed
+  if !runner.includeHidden && strings.HasPrefix(name, ".") {
+    runner.logSkip("hidden", path)
+    return errSkipWalk
+  }
+
+  if runner.thumbnails && name == thumbsDirName {
+    return errSkipWalk
+  }
+
+  if runner.isExcluded(name) {
+    runner.logSkip("excluded", path)
+    return errSkipWalk
+  }
+
+  absPath, err := filepath.Abs(path)
+
+  if err != nil {
+    return err
+  }
+
+  if runner.isExcludedPath(absPath) {
+    runner.logSkip("excluded", path)
+    return errSkipWalk
+  }
+
+  if runner.gitignore && runner.isGitignored(absPath, true) {
+    runner.logSkip("ignored", path)
+    return errSkipWalk
+  }
+
+  return nil
+}
+
+// continueOnError wraps visit for --keep-going, so that errors are reported
+// and counted rather than ending the walk.
+func (runner *RootCmdRunner) continueOnError(
+  visit func(string) error,
+) func(string) error {
+  return func(dir string) error {
+    if err := visit(dir); err != nil {
+      runner.logError(err)
+      runner.countError()
+    }
+
+    return nil
+  }
+}
+
+// walkDepth returns how many levels below start path is, 0 being start itself.
+func walkDepth(start string, path string) int {
+  rel, err := filepath.Rel(start, path)
+
+  if err != nil || rel == "." {
+    return 0
+  }
+
+  return strings.Count(rel, string(filepath.Separator)) + 1
+}
+
+// collectDirs walks the start directories and returns every directory that
+// would be indexed, in the order of the walk.
+func (runner *RootCmdRunner) collectDirs() ([]string, error) {
+  var dirs []string
+
+  err := runner.walkStartDirs(func(dir string) error {
+    dirs = append(dirs, dir)
+    return nil
+  })
+
+  return dirs, err
+}
+
+// processInParallel collects the directories to index first and then hands
+// them out to --jobs workers.
+func (runner *RootCmdRunner) processInParallel() error {
+  dirs, err := runner.collectDirs()
+
+  if err != nil {
+    return err
+  }
+
+  runner.state.progress.setTotal(len(dirs))
+
+  return runner.inParallel(len(dirs), func(i int) error {
+    return runner.processDirectory(dirs[i])
+  })
+}
+
+// inParallel calls visit with each index below n, on up to --jobs at a time.
+// Each directory gets its own dirContext, so the workers only share what's in
+// runState. The first error stops the remaining indexes from being visited,
+// unless --keep-going is set.
+func (runner *RootCmdRunner) inParallel(n int, visit func(int) error) error {
+  queue := make(chan int)
+  var wg sync.WaitGroup
+  var mu sync.Mutex
+  var firstErr error
+
+  for i := 0; i < runner.jobs; i++ {
+    wg.Add(1)
+
+    go func() {
+      defer wg.Done()
+
+      for index := range queue {
+        mu.Lock()
+        failed := firstErr != nil
+        mu.Unlock()
+
+        if failed {
+          continue
+        }
+
+        err := visit(index)
+
+        if err != nil && runner.keepGoing {
+          runner.logError(err)
+          runner.countError()
+          continue
+        }
+
+        if err != nil {
+          mu.Lock()
+
+          if firstErr == nil {
+            firstErr = err
+          }
+
+          mu.Unlock()
+        }
+      }
+    }()
+  }
+
+  for i := 0; i < n; i++ {
+    queue <- i
+  }
+
+  close(queue)
+  wg.Wait()
+
+  return firstErr
+}
+
+func (runner *RootCmdRunner) processDirectory(dir string) error {
+  defer runner.state.progress.add()
+
+  if runner.skipRoot && runner.isStartDir(dir) {
+    runner.logSkip("starting directory", dir)
+    return nil
+  }
+
+  ctx, err := runner.prepare(dir)
+
+  if err != nil {
+    return err
+  }
+
+  return ctx.execute()
+}
+
+// prepare returns a new context for indexing dir, so that nothing carries
+// over from one directory to the next and directories can be indexed at the
+// same time.
+func (runner *RootCmdRunner) prepare(dir string) (*dirContext, error) {
+  // a trailing slash, or a doubled one, shouldn't make a difference to the
+  // paths worked out from this, nor to what's logged
+  ctx := &dirContext{
+    RootCmdRunner: runner,
+    dirRelative: filepath.Clean(dir),
+  }
+
+  ctx.templateData = IndexTemplate{
+    Theme: runner.theme,
+    ExtraCSS: runner.extraCSS,
+    StylesheetURL: runner.stylesheetURL,
+    HeaderHTML: runner.headerHTML,
+    FooterHTML: runner.footerHTML,
+    DateFormat: runner.dateFormat,
+    LocalizeDates: runner.localizeDates,
+    RelativeTime: runner.relativeTime,
+    Search: runner.search,
+    ShowPerms: runner.showPerms,
+    NoIndex: runner.noRobots,
+    Preview: runner.preview,
+    HideSize: runner.noSize,
+    HideDate: runner.noDate,
+    HideIcons: runner.noTypeIcon,
+    UpLabel: runner.upLabel,
+    OGTags: runner.ogTags,
+    OGImage: runner.ogImage,
+    SizeUnits: runner.sizeUnits,
+    Extra: runner.extra,
+  }
+
+  err := ctx.resolveDirectories()
+
+  if err != nil {
+    return nil, err
+  }
+
+  // a link preview needs the full url of the page, so there's none without a
+  // base url
+  if runner.ogTags && runner.baseUrl != "" {
+    ctx.templateData.OGURL = runner.absoluteDirURL(ctx.dirChrooted)
+  }
+
+  if ctx.templateData.CanGoUp {
+    if !runner.absoluteLinks() {
+      ctx.templateData.ParentURL = "../"
+    } else {
+      ctx.templateData.ParentURL = runner.absoluteDirURL(
+        path.Dir(ctx.dirChrooted),
+      )
+    }
+  }
+
+  return ctx, nil
+}
+
+func (ctx *dirContext) execute() error {
+  fetch := ctx.fetchData
+
+  if ctx.stream {
+    fetch = ctx.fetchCounts
+  }
+
+  err := fetch()
+
+  if err != nil {
+    return err
+  }
+
+  return ctx.publish()
+}
+
+// publish writes the index, and whatever goes with it, for a directory whose
+// data has been fetched already.
+func (ctx *dirContext) publish() error {
+  var err error
+
+  ctx.templateData.Name, err = ctx.renderTitle()
+
+  if err != nil {
+    return err
+  }
+
+  if ctx.summaryPage {
+    ctx.countIndexed()
+  }
+
+  numDirs := ctx.templateData.NumDirs
+  numFiles := ctx.templateData.NumFiles
+
+  ctx.emit(
+    logVerbose,
+    indexEvent{
+      Action: "index",
+      Path: ctx.dirRelative,
+      Dirs: numDirs,
+      Files: numFiles,
+      Items: ctx.templateData.NumItems,
+    },
+    "%s: %d directories, %d files", ctx.dirRelative, numDirs, numFiles,
+  )
+
+  // hidden and excluded entries aren't counted, so a directory with nothing
+  // but those is empty too
+  if ctx.skipEmpty && ctx.templateData.NumDirs + ctx.templateData.NumFiles == 0 {
+    ctx.logAction("skip empty", ctx.dirRelative)
+    ctx.countSkipped("empty")
+    return nil
+  }
+
+  ctx.generateBreadcrumbs()
+  err = ctx.render()
+
+  if isSkipError(err) {
+    ctx.logSkipped(err)
+    ctx.hintHandWritten(err)
+    ctx.countSkipped(skipReason(err))
+    return nil
+  }
+
+  if err != nil {
+    return err
+  }
+
+  ctx.countFiles(ctx.templateData.NumFiles)
+
+  if ctx.sitemap {
+    return ctx.addSitemapEntry()
+  }
+
+  return nil
+}
+
+// parseSince parses --since, which is either a duration back from now, such
+// as 168h, or a point in time in RFC 3339 or as a plain date in loc.
+func parseSince(value string, loc *time.Location) (time.Time, error) {
+  if d, err := time.ParseDuration(value); err == nil {
+    return time.Now().Add(-d), nil
+  }
+
+  if t, err := time.Parse(time.RFC3339, value); err == nil {
+    return t, nil
+  }
+
+  if t, err := time.ParseInLocation("2006-01-02", value, loc); err == nil {
+    return t, nil
+  }
+
+  return time.Time{}, fmt.Errorf(
+    "invalid since, expected a duration such as 168h or a date: %s", value,
+  )
+}
+
+// parseSourceDate returns the time in SOURCE_DATE_EPOCH, the convention for
+// reproducible builds, or the unix epoch if it isn't set.
+func parseSourceDate() (time.Time, error) {
+  value := os.Getenv("SOURCE_DATE_EPOCH")
+
+  if value == "" {
+    return time.Unix(0, 0), nil
+  }
+
+  seconds, err := strconv.ParseInt(value, 10, 64)
+
+  if err != nil {
+    return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH: %s", value)
+  }
+
+  return time.Unix(seconds, 0), nil
+}
+
+// clampModTime limits t to the source date with --reproducible, so that a
+// fresh checkout of the same files produces the same output.
+func (runner *RootCmdRunner) clampModTime(t time.Time) time.Time {
+  if runner.reproducible && t.After(runner.sourceDate) {
+    return runner.sourceDate.In(t.Location())
+  }
+
+  return t
+}
+
+// titleData is what a --title template is evaluated with.
+type titleData struct {
+  Dir string
+  Name string
+  NumDirs int
+  NumFiles int
+}
+
+// renderTitle evaluates the --title template for the current directory,
+// whose contents have to be fetched first for the counts.
+func (ctx *dirContext) renderTitle() (string, error) {
+  var buf strings.Builder
+
+  err := ctx.titleTmpl.Execute(&buf, titleData{
+    Dir: ctx.dirChrooted,
+    Name: path.Base(ctx.dirChrooted),
+    NumDirs: ctx.templateData.NumDirs,
+    NumFiles: ctx.templateData.NumFiles,
+  })
+
+  if err != nil {
+    return "", fmt.Errorf("invalid title template: %w", err)
+  }
+
+  return buf.String(), nil
+}
+
+func parseTimezone(name string) (*time.Location, error) {
+  if strings.ToLower(name) == "local" {
+    return time.Local, nil
+  }
+
+  loc, err := time.LoadLocation(name)
+
+  if err != nil {
+    return nil, fmt.Errorf("unknown timezone: %s", name)
+  }
+
+  return loc, nil
+}
+
+const defaultDateFormat = "01/02/2006 03:04:05 PM -07:00"
+
+var dateFormatAliases = map[string]string{
+  "iso": time.RFC3339,
+  "rfc822": time.RFC822,
+  "rfc1123": time.RFC1123,
+  "date": "2006-01-02",
+}
+
+// parseDateFormat resolves the --date-format aliases. Any string is a valid
+// time layout as far as Go is concerned, so a layout that doesn't contain a
+// single date or time element is rejected as a likely typo.
+func parseDateFormat(format string) (string, error) {
+  if format == "" {
+    return defaultDateFormat, nil
+  }
+
+  if layout, ok := dateFormatAliases[format]; ok {
+    return layout, nil
+  }
+
+  if time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC).Format(format) == format {
+    return "", fmt.Errorf("invalid date format: %s", format)
+  }
+
+  return format, nil
+}
+
+// loadCSS figures out what kind of value --css is. Urls, and paths to .css
+// files that don't exist locally, are linked as a stylesheet. Local files are
+// read once and inlined, as is anything else.
+func (runner *RootCmdRunner) loadCSS() error {
+  css := runner.css
+
+  switch {
+  case css == "":
+    return nil
+
+  case strings.HasPrefix(css, "http://") ||
+    strings.HasPrefix(css, "https://") ||
+    strings.HasPrefix(css, "//"):
+
+    runner.stylesheetURL = css
+    return nil
+  }
+
+  data, err := os.ReadFile(css)
+
+  switch {
+  case err == nil:
+    css = string(data)
+
+  case strings.HasSuffix(css, ".css") && errors.Is(err, fs.ErrNotExist):
+    runner.stylesheetURL = css
+    return nil
+  }
+
+  // the css ends up verbatim inside a <style> element, so make sure it can't
+  // close the element early. "<\/" means the same thing in css.
+  runner.extraCSS = template.CSS(strings.ReplaceAll(css, "</", "<\\/"))
+  return nil
+}
+
+// validateBaseURL checks that --base-url is either an absolute http or https
+// url with a host, or a path starting with a slash, such as /static. Anything
+// else would only show up as broken links in the browser.
+func validateBaseURL(baseURL string) error {
+  if baseURL == "" {
+    return nil
+  }
+
+  if strings.TrimSpace(baseURL) != baseURL {
+    return fmt.Errorf("invalid base url %q: has leading or trailing spaces", baseURL)
+  }
+
+  u, err := url.Parse(baseURL)
+
+  if err != nil {
+    return fmt.Errorf("invalid base url: %w", err)
+  }
+
+  switch {
+  case u.RawQuery != "" || u.Fragment != "" || strings.HasSuffix(baseURL, "?"):
+    return fmt.Errorf("invalid base url %q: can't have a query or fragment", baseURL)
+
+  case u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https":
+    return fmt.Errorf("invalid base url %q: scheme must be http or https", baseURL)
+
+  case u.Scheme != "" && u.Host == "":
+    return fmt.Errorf("invalid base url %q: missing host", baseURL)
+
+  case u.Scheme == "" && !strings.HasPrefix(baseURL, "/"):
+    return fmt.Errorf(
+      "invalid base url %q: must start with http://, https:// or /", baseURL,
+    )
+  }
+
+  return nil
+}
+
+// readHTMLFile reads an html fragment for --header-file or --footer-file. It's
+// trusted, like the template, so it's inlined as is.
+func readHTMLFile(path string) (template.HTML, error) {
+  if path == "" {
+    return "", nil
+  }
+
+  data, err := os.ReadFile(path)
+
+  if err != nil {
+    return "", err
+  }
+
+  return template.HTML(data), nil
+}
+
+// isSkipError reports whether err means the target was left alone on purpose,
+// which is reported but doesn't fail the run.
+func isSkipError(err error) bool {
+  return errors.Is(err, errTargetIsADirectory) ||
+    errors.Is(err, errTargetExistsAndIsNotGenerated)
+}
+
+func (runner *RootCmdRunner) parseArgs(args []string) error {
+  var err error
+
+  runner.startDirs = defaultDirs(args)
+
+  // checked here rather than with MarkFlagRequired, since cobra validates
+  // required flags before the config file is loaded
+  if runner.rootRelative == "" {
+    return fmt.Errorf(`required flag(s) "root" not set`)
+  }
+
+  runner.rootRelative = filepath.Clean(runner.rootRelative)
+  runner.rootAbsolute, err = filepath.Abs(runner.rootRelative)
+
+  if err != nil {
+    return err
+  }
+
+  if runner.fsys == nil {
+    runner.fsys = os.DirFS(runner.rootAbsolute)
+  }
+
+  if runner.jobs < 1 {
+    return fmt.Errorf("invalid number of jobs: %d", runner.jobs)
+  }
+
+  if runner.jobs > 1 && runner.stdout {
+    return fmt.Errorf("--jobs cannot be combined with --stdout")
+  }
+
+  if runner.watch && runner.stdout {
+    return fmt.Errorf("--watch cannot be combined with --stdout")
+  }
+
+  // an image is only of use in the tags
+  if runner.ogImage != "" {
+    runner.ogTags = true
+  }
+
+  if runner.collectsStdout() && runner.format != "json" {
+    return fmt.Errorf(
+      "--stdout with --recursive or several directories requires --format json",
+    )
+  }
+
+  for _, pattern := range runner.excludes {
+    if _, err := filepath.Match(pattern, ""); err != nil {
+      return fmt.Errorf("invalid exclude pattern: %s", pattern)
+    }
+  }
+
+  // relative paths are taken from the current directory, like the arguments
+  for i, excludePath := range runner.excludePaths {
+    runner.excludePaths[i], err = filepath.Abs(excludePath)
+
+    if err != nil {
+      return err
+    }
+  }
+
+  err = validateBaseURL(runner.baseUrl)
+
+  if err != nil {
+    return err
+  }
+
+  if runner.sitemap && runner.baseUrl == "" {
+    return fmt.Errorf("--sitemap requires --base-url")
+  }
+
+  if runner.rss && runner.baseUrl == "" {
+    return fmt.Errorf("--rss requires --base-url")
+  }
+
+  if runner.summaryPage && !runner.recursive {
+    return fmt.Errorf("--summary-page requires --recursive")
+  }
+
+  if runner.twoPass && !runner.recursive {
+    return fmt.Errorf("--two-pass requires --recursive")
+  }
+
+  if runner.twoPass && runner.watch {
+    return fmt.Errorf("--two-pass cannot be combined with --watch")
+  }
+
+  if runner.skipRoot && !runner.recursive {
+    return fmt.Errorf("--skip-root requires --recursive")
+  }
+
+  if runner.readmeMarkdown && !runner.readme {
+    return fmt.Errorf("--readme-markdown requires --readme")
+  }
+
+  if runner.format != "html" && runner.format != "json" {
+    return fmt.Errorf("invalid format: %s", runner.format)
+  }
+
+  // the index goes next to the files it lists, so it has to be a plain name
+  if runner.indexName == "" || runner.indexName == "." || runner.indexName == ".." ||
+    strings.ContainsAny(runner.indexName, `/\`) {
+    return fmt.Errorf("invalid index name: %s", runner.indexName)
+  }
+
+  if runner.marker == "" {
+    return fmt.Errorf("marker must not be empty")
+  }
+
+  if runner.checksums != "" && !isValidChecksumAlgorithm(runner.checksums) {
+    return fmt.Errorf("invalid checksum algorithm: %s", runner.checksums)
+  }
+
+  if runner.hashJobs < 0 {
+    return fmt.Errorf("invalid number of hash jobs: %d", runner.hashJobs)
+  }
+
+  if runner.checksumVerify && runner.checksums == "" {
+    return fmt.Errorf("--checksum-verify requires --checksums")
+  }
+
+  if runner.checksumVerify && runner.watch {
+    return fmt.Errorf("--checksum-verify cannot be combined with --watch")
+  }
+
+  if runner.theme != "light" && runner.theme != "dark" && runner.theme != "auto" {
+    return fmt.Errorf("invalid theme: %s", runner.theme)
+  }
+
+  if _, ok := themeTemplates[runner.themeName]; !ok {
+    return fmt.Errorf("invalid theme name: %s (expected table or grid)", runner.themeName)
+  }
+
+  if runner.templatePath != "" && runner.themeName != "table" {
+    return fmt.Errorf("--theme-name cannot be combined with --template")
+  }
+
+  if runner.sizeUnits != "iec" && runner.sizeUnits != "si" {
+    return fmt.Errorf("invalid size units: %s", runner.sizeUnits)
+  }
+
+  runner.location, err = parseTimezone(runner.timezone)
+
+  if err != nil {
+    return err
+  }
+
+  if runner.since != "" {
+    runner.sinceTime, err = parseSince(runner.since, runner.location)
+
+    if err != nil {
+      return err
+    }
+  }
+
+  // the default format is only a fallback for the browser's locale
+  runner.localizeDates = runner.dateFormat == "" && !runner.relativeTime
+  runner.dateFormat, err = parseDateFormat(runner.dateFormat)
+
+  if err != nil {
+    return err
+  }
+
+  err = runner.loadCSS()
+
+  if err != nil {
+    return err
+  }
+
+  runner.headerHTML, err = readHTMLFile(runner.headerFile)
+
+  if err != nil {
+    return err
+  }
+
+  runner.footerHTML, err = readHTMLFile(runner.footerFile)
+
+  if err != nil {
+    return err
+  }
+
+  // executing it once catches unknown fields as well as syntax errors
+  runner.titleTmpl, err = texttemplate.New("title").Parse(runner.title)
+
+  if err == nil {
+    err = runner.titleTmpl.Execute(io.Discard, titleData{})
+  }
+
+  if err != nil {
+    return fmt.Errorf("invalid title template: %w", err)
+  }
+
+  // parsed once up front, so that a broken template fails the run before
+  // anything has been written
+  if runner.format == "html" {
+    runner.tmpl, err = runner.parseTemplate()
+
+    if err != nil {
+      return err
+    }
+  }
+
+  if runner.minSize != "" {
+    runner.minBytes, err = humanize.ParseBytes(runner.minSize)
+
+    if err != nil {
+      return fmt.Errorf("invalid min size: %s", runner.minSize)
+    }
+  }
+
+  if runner.maxSize != "" {
+    runner.maxBytes, err = humanize.ParseBytes(runner.maxSize)
+
+    if err != nil {
+      return fmt.Errorf("invalid max size: %s", runner.maxSize)
+    }
+  }
+
+  if runner.maxItems < 0 {
+    return fmt.Errorf("invalid max items: %d", runner.maxItems)
+  }
+
+  if runner.pageSize < 0 {
+    return fmt.Errorf("invalid page size: %d", runner.pageSize)
+  }
+
+  if runner.rssLimit < 1 {
+    return fmt.Errorf("invalid rss limit: %d", runner.rssLimit)
+  }
+
+  if runner.reproducible {
+    runner.sourceDate, err = parseSourceDate()
+
+    if err != nil {
+      return err
+    }
+
+    // relative times depend on when the page was generated, and other sort
+    // keys could depend on the clamped times
+    runner.sortBy = "name"
+    runner.relativeTime = false
+  }
+
+  if !isValidSortKey(runner.sortBy) {
+    return fmt.Errorf("invalid sort key: %s", runner.sortBy)
+  }
+
+  if runner.collate != "" {
+    runner.collateTag, err = language.Parse(runner.collate)
+
+    if err != nil {
+      return fmt.Errorf("invalid collation locale: %s", runner.collate)
+    }
+  }
+
+  if runner.groupBy != "" && runner.groupBy != "type" {
+    return fmt.Errorf("invalid group key: %s", runner.groupBy)
+  }
+
+  if runner.stream {
+    err = runner.checkStream()
+
+    if err != nil {
+      return err
+    }
+  }
+
+  if len(runner.sets) > 0 {
+    runner.extra = map[string]string{}
+  }
+
+  // shared by every directory, templates only read it
+  for _, set := range runner.sets {
+    key, value, found := strings.Cut(set, "=")
+
+    if !found || key == "" {
+      return fmt.Errorf("invalid --set, expected key=value: %s", set)
+    }
+
+    runner.extra[key] = value
+  }
+
+  return nil
+}
+
+func (ctx *dirContext) resolveDirectories() error {
+  var err error
+
+  ctx.dirAbsolute, err = filepath.Abs(ctx.dirRelative)
+
+  if err != nil {
+    return err
+  }
+
+  ctx.dirRelativeToRoot, err = filepath.Rel(
+    ctx.rootAbsolute,
+    ctx.dirAbsolute,
+  )
+
+  if strings.HasPrefix(ctx.dirRelativeToRoot, "..") {
+    return fmt.Errorf("directory is outside root")
+  }
+
+  ctx.dirChrooted = filepath.ToSlash(
+    filepath.Join("/", ctx.dirRelativeToRoot),
+  )
+  ctx.dirInFS = filepath.ToSlash(ctx.dirRelativeToRoot)
+  ctx.templateData.CanGoUp = !ctx.noUpLink &&
+    ctx.dirAbsolute != ctx.rootAbsolute
+
+  return err
+}
+
+func (ctx *dirContext) fetchData() error {
+  files, ignored, gitTimes, err := ctx.readEntries()
+
+  if err != nil {
+    return err
+  }
+
+  var toHash []int
+
+  for _, dirEntry := range files {
+    item, info, ok, err := ctx.newItem(dirEntry, ignored, gitTimes)
+
+    if err != nil {
+      return err
+    }
+
+    if !ok {
+      continue
+    }
+
+    err = ctx.completeItem(&item, info)
+
+    if err != nil {
+      return err
+    }
+
+    if ctx.checksums != "" && info.Mode().IsRegular() {
+      toHash = append(toHash, len(ctx.templateData.Items))
+    }
+
+    ctx.templateData.Items = append(ctx.templateData.Items, item)
+    ctx.countItem(item)
+  }
+
+  ctx.templateData.ComputedDirSizes = ctx.computeDirSizes
+  ctx.templateData.CountedChildren = ctx.countChildren
+
+  if len(toHash) > 0 {
+    ctx.computeChecksums(toHash)
+  }
+
+  if ctx.readme {
+    ctx.templateData.Readme, err = ctx.readReadme(ignored)
+
+    if err != nil {
+      return err
+    }
+  }
+
+  ctx.sortItems()
+  return nil
+}
+
+// readEntries reads the directory along with what decides which of its
+// entries are listed: the .indexignore patterns and, with --git-times, the
+// commit times.
+func (ctx *dirContext) readEntries() (
+  []fs.DirEntry,
+  []string,
+  map[string]time.Time,
+  error,
+) {
+  files, err := fs.ReadDir(ctx.fsys, ctx.dirInFS)
+
+  if err != nil {
+    return nil, nil, nil, err
+  }
+
+  ignored, err := readIndexignore(ctx.fsys, ctx.dirInFS)
+
+  if err != nil {
+    return nil, nil, nil, err
+  }
+
+  var gitTimes map[string]time.Time
+
+  if ctx.gitTimes {
+    gitTimes = ctx.gitModTimes()
+  }
+
+  return files, ignored, gitTimes, nil
+}
+
+// newItem applies the filters to dirEntry and returns its item, with only
+// what the filters need filled in, or false if it isn't listed.
+func (ctx *dirContext) newItem(
+  dirEntry fs.DirEntry,
+  ignored []string,
+  gitTimes map[string]time.Time,
+) (DirectoryItem, fs.FileInfo, bool, error) {
+  info, err := dirEntry.Info()
+
+  if err != nil {
+    return DirectoryItem{}, nil, false, err
+  }
+
+  name := dirEntry.Name()
+  isDir := isDirEntry(ctx.fsys, ctx.dirInFS, dirEntry)
+
+  // git never treats a symlink as a directory
+  if ctx.isIgnored(ctx.dirAbsolute, name, dirEntry.IsDir(), ignored) {
+    return DirectoryItem{}, nil, false, nil
+  }
+
+  if !isDir && ctx.onlyDirs {
+    return DirectoryItem{}, nil, false, nil
+  }
+
+  if !isDir && !ctx.isIncludedExt(name) {
+    return DirectoryItem{}, nil, false, nil
+  }
+
+  item := DirectoryItem{
+    URL: ctx.itemURL(name, isDir),
+    IsDir: isDir,
+    IsSymlink: info.Mode() & fs.ModeSymlink > 0,
+    Name: dirEntry.Name(),
+    Size: info.Size(),
+    ModTime: info.ModTime().In(ctx.location),
+    Mode: info.Mode().String(),
+    SizeUnits: ctx.sizeUnits,
+  }
+
+  if t, ok := gitTimes[name]; ok {
+    item.ModTime = t.In(ctx.location)
+  }
+
+  if !item.IsDir && !ctx.isModifiedSince(item.ModTime) {
+    return DirectoryItem{}, nil, false, nil
+  }
+
+  item.ModTime = ctx.clampModTime(item.ModTime)
+
+  if !item.IsDir && !ctx.isInSizeRange(item.Size) {
+    return DirectoryItem{}, nil, false, nil
+  }
+
+  return item, info, true, nil
+}
+
+// completeItem fills in the rest of a listed item, which takes reading more
+// than the directory itself.
+func (ctx *dirContext) completeItem(item *DirectoryItem, info fs.FileInfo) error {
+  var err error
+  name := item.Name
+
+  if item.IsSymlink {
+    err = item.resolveSymlink(filepath.Join(ctx.dirAbsolute, name))
+
+    if err != nil {
+      return err
+    }
+  }
+
+  if !item.IsDir {
+    item.MimeType = ctx.detectMimeType(ctx.itemPath(name))
+  }
+
+  if ctx.thumbnails && info.Mode().IsRegular() && isThumbnailable(name) {
+    item.ThumbURL, err = ctx.thumbnail(name, info.ModTime())
+
+    if err != nil {
+      return err
+    }
+  }
+
+  if item.IsDir && !ctx.listedDirs[filepath.Join(ctx.dirAbsolute, name)] {
+    ctx.measureDir(item)
+  }
+
+  return nil
+}
+
+// measureDir reads the size and the number of children of a subdirectory,
+// as far as the flags ask for them.
+func (ctx *dirContext) measureDir(item *DirectoryItem) {
+  if ctx.computeDirSizes {
+    item.Size = ctx.dirSize(ctx.itemPath(item.Name))
+    item.sizeComputed = true
+  }
+
+  if ctx.countChildren {
+    item.ChildCount = ctx.countDirChildren(ctx.itemPath(item.Name))
+  }
+}
+
+// countItem adds a listed item to the counts and the total size.
+func (ctx *dirContext) countItem(item DirectoryItem) {
+  if item.IsDir {
+    ctx.templateData.NumDirs += 1
+  } else {
+    ctx.templateData.NumFiles += 1
+  }
+
+  ctx.templateData.NumItems += 1
+
+  // a symlink's own size is just the length of its target path, and the
+  // target is counted where it actually lives
+  if !item.IsSymlink && (!item.IsDir || ctx.computeDirSizes) {
+    ctx.templateData.TotalSize += item.Size
+  }
+}
+
+// isOutputName reports whether name is one of the files indexify writes to
+// dir, an absolute path, which are left out of the listing.
+func (runner *RootCmdRunner) isOutputName(dir string, name string) bool {
+  _, ok := runner.outputCheck(dir, name, false)
+  return ok
+}
+
+// outputCheck returns how to recognize the file called name in dir, an
+// absolute path, as one that indexify wrote, if name is one of its outputs.
+// The check is nil for outputs that can't be recognized, such as the
+// thumbnail directory. With anyOption, the outputs of every option count
+// rather than just the ones that are set, for clean, which doesn't know what
+// the files were generated with.
+func (runner *RootCmdRunner) outputCheck(
+  dir string,
+  name string,
+  anyOption bool,
+) (func(string) bool, bool) {
+  var check func(string) bool
+  isGzip := false
+
+  if (anyOption || runner.backup) && strings.HasSuffix(name, backupSuffix) {
+    name = strings.TrimSuffix(name, backupSuffix)
+  }
+
+  if (anyOption || runner.gzip) && strings.HasSuffix(name, gzipSuffix) {
+    name = strings.TrimSuffix(name, gzipSuffix)
+    isGzip = true
+  }
+
+  htmlName := runner.indexName
+  jsonName := jsonIndexName(runner.indexName)
+
+  switch {
+  case name == runner.renderTargetName() || runner.isPageName(name):
+    check = runner.isGeneratedContent
+
+  case name == htmlName || anyOption && isNumberedPage(htmlName, name):
+    check = runner.isGeneratedHTML
+
+  case anyOption && (name == jsonName || isNumberedPage(jsonName, name)):
+    check = isGeneratedJSON
+
+  case (anyOption || runner.rss) && name == feedName:
+    check = isGeneratedFeed
+
+  case runner.checksums != "" && name == runner.checksumsName():
+    check = isGeneratedChecksums
+
+  case anyOption && isChecksumsName(name):
+    check = isGeneratedChecksums
+
+  case runner.thumbnails && name == thumbsDirName:
+    return nil, true
+
+  case (anyOption || runner.sitemap) && name == sitemapName:
+    check = isGeneratedSitemap
+
+  case (anyOption || runner.summaryPage) && name == summaryPageName:
+    check = isGeneratedSummaryPage
+
+  default:
+    return nil, false
+  }
+
+  if (name == sitemapName || name == summaryPageName) && dir != runner.rootAbsolute {
+    return nil, false
+  }
+
+  if isGzip {
+    isGenerated := check
+    check = func(data string) bool {
+      return isGenerated(gunzipString(data))
+    }
+  }
+
+  return check, true
+}
+
+// isIgnored reports whether the entry called name in dir, an absolute path,
+// is left out of the listing whatever else it is: it's hidden, one of the
+// outputs, excluded, or ignored by .indexignore, whose patterns are in
+// ignored, or with --gitignore, by a .gitignore. isDir is whether git sees
+// the entry as a directory.
+func (runner *RootCmdRunner) isIgnored(
+  dir string,
+  name string,
+  isDir bool,
+  ignored []string,
+) bool {
+  switch {
+  case !runner.includeHidden && strings.HasPrefix(name, "."):
+    return true
+
+  case runner.isUnlistedOutput(dir, name) || runner.isExcluded(name):
+    return true
+
+  case name == indexignoreName || matchesAny(ignored, name):
+    return true
+  }
+
+  return runner.gitignore && runner.isGitignored(filepath.Join(dir, name), isDir)
+}
+
+// isUnlistedOutput reports whether name is an output file that is left out of
+// the listing, which with --show-index is every one except the index itself.
+// Its sidecars, such as the .gz copy and other pages, stay hidden.
+func (runner *RootCmdRunner) isUnlistedOutput(dir string, name string) bool {
+  if runner.showIndex && name == runner.renderTargetName() {
+    return false
+  }
+
+  return runner.isOutputName(dir, name)
+}
+
+// isExcluded reports whether name matches any of the --exclude patterns.
+// Exclusion is independent of --hidden: an excluded entry is skipped even when
+// hidden files are included.
+func (runner *RootCmdRunner) isExcluded(name string) bool {
+  return matchesAny(runner.excludes, name)
+}
+
+// isExcludedPath reports whether the directory at absPath is one of the
+// --exclude-path directories. Their descendants aren't checked, since the
+// walk never gets that far.
+func (runner *RootCmdRunner) isExcludedPath(absPath string) bool {
+  for _, excludePath := range runner.excludePaths {
+    if absPath == excludePath {
+      return true
+    }
+  }
+
+  return false
+}
+
+// isIncludedExt reports whether a file named name passes the --include-ext
+// filter. The extensions are matched as suffixes, case-insensitively, so
+// multi-part ones such as .tar.gz work too.
+func (runner *RootCmdRunner) isIncludedExt(name string) bool {
+  if len(runner.includeExts) == 0 {
+    return true
+  }
+
+  name = strings.ToLower(name)
+
+  for _, ext := range runner.includeExts {
+    ext = "." + strings.TrimPrefix(strings.ToLower(ext), ".")
+
+    if strings.HasSuffix(name, ext) {
+      return true
+    }
+  }
+
+  return false
+}
+
+// isInSizeRange reports whether a file of size bytes passes the --min-size
+// and --max-size filters.
+func (runner *RootCmdRunner) isInSizeRange(size int64) bool {
+  if runner.minSize != "" && uint64(size) < runner.minBytes {
+    return false
+  }
+
+  if runner.maxSize != "" && uint64(size) > runner.maxBytes {
+    return false
+  }
+
+  return true
+}
+
+// isModifiedSince reports whether a file modified at modTime passes the
+// --since filter.
+func (runner *RootCmdRunner) isModifiedSince(modTime time.Time) bool {
+  return runner.since == "" || modTime.After(runner.sinceTime)
+}
+
+// itemPath returns the path of the named item in runner.fsys.
+func (ctx *dirContext) itemPath(name string) string {
+  return path.Join(ctx.dirInFS, name)
+}
+
+// detectMimeType looks up the type of the file at name, a path in
+// runner.fsys, by its extension and, unless --no-sniff is set, falls back to
+// sniffing the first 512 bytes.
+func (runner *RootCmdRunner) detectMimeType(name string) string {
+  mimeType := mime.TypeByExtension(path.Ext(name))
+
+  if mimeType == "" && !runner.noSniff {
+    mimeType = sniffMimeType(runner.fsys, name)
+  }
+
+  // drop parameters such as "; charset=utf-8"
+  mimeType, _, _ = strings.Cut(mimeType, ";")
+  return strings.TrimSpace(mimeType)
+}
+
+func sniffMimeType(fsys fs.FS, name string) string {
+  f, err := fsys.Open(name)
+
+  if err != nil {
+    return ""
+  }
+
+  defer f.Close()
+
+  buf := make([]byte, 512)
+  n, err := io.ReadFull(f, buf)
+
+  if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+    return ""
+  }
+
+  return http.DetectContentType(buf[:n])
+}
+
+// dirSize sums up the sizes of all regular files below dir, a path in
+// runner.fsys. Symlinks are only followed with --follow-symlinks, as when
+// indexing, and no directory is counted twice. Unreadable subdirectories are
+// skipped rather than failing the whole listing.
+func (runner *RootCmdRunner) dirSize(dir string) int64 {
+  var total int64
+
+  // the trailing separator measures a symlinked directory by its target
+  start := filepath.Join(runner.rootAbsolute, filepath.FromSlash(dir)) +
+    string(filepath.Separator)
+
+  add := func(path string, d fs.DirEntry, err error) error {
+    if err != nil {
+      return nil
+    }
+
+    if !d.Type().IsRegular() {
+      return nil
+    }
+
+    info, err := d.Info()
+
+    if err == nil {
+      total += info.Size()
+    }
+
+    return nil
+  }
+
+  walkSubtree(start, runner.followSymlinks, visitedDirs{}, add)
+  return total
+}
+
+// resolveSymlink records where the symlink at path points to, as written in
+// the link. A relative target is resolved against the link's own directory
+// to tell whether the link is broken.
+func (item *DirectoryItem) resolveSymlink(path string) error {
+  target, err := os.Readlink(path)
+
+  if err != nil {
+    return err
+  }
+
+  item.SymlinkTarget = target
+
+  if _, err := os.Stat(path); err != nil {
+    item.SymlinkBroken = true
+  }
+
+  return nil
+}
+
+// isDirEntry reports whether entry, in dir, is a directory or a symlink to
+// one. A broken symlink counts as a file.
+func isDirEntry(fsys fs.FS, dir string, entry fs.DirEntry) bool {
+  if entry.Type() & fs.ModeSymlink == 0 {
+    return entry.IsDir()
+  }
+
+  info, err := fs.Stat(fsys, path.Join(dir, entry.Name()))
+  return err == nil && info.IsDir()
+}
+
+// countDirChildren returns how many entries of dir, a path in runner.fsys,
+// would be listed in its own index, or -1 if it can't be read.
+func (runner *RootCmdRunner) countDirChildren(dir string) int {
+  // a broken .indexignore just means nothing is left out of the count
+  ignored, _ := readIndexignore(runner.fsys, dir)
+  dirAbsolute := filepath.Join(runner.rootAbsolute, filepath.FromSlash(dir))
+  start := dirAbsolute + string(filepath.Separator)
+  count := 0
+
+  add := func(path string, entry fs.DirEntry, err error) error {
+    if err != nil {
+      return err
+    }
+
+    if path == start {
+      return nil
+    }
+
+    if runner.isCountedChild(dir, dirAbsolute, entry, ignored) {
+      count += 1
+    }
+
+    // only the entries of dir itself are counted
+    if entry.IsDir() {
+      return filepath.SkipDir
+    }
+
+    return nil
+  }
+
+  err := walkSubtree(start, false, visitedDirs{}, add)
+
+  if err != nil {
+    runner.logf(logVerbose, "can't count entries of %s: %v", dir, err)
+    return -1
+  }
+
+  return count
+}
+
+// isCountedChild reports whether entry, in dir, is one that its index lists.
+func (runner *RootCmdRunner) isCountedChild(
+  dir string,
+  dirAbsolute string,
+  entry fs.DirEntry,
+  ignored []string,
+) bool {
+  name := entry.Name()
+  isDir := isDirEntry(runner.fsys, dir, entry)
+
+  if runner.isIgnored(dirAbsolute, name, entry.IsDir(), ignored) {
+    return false
+  }
+
+  if !isDir && runner.onlyDirs {
+    return false
+  }
+
+  if !isDir && !runner.isIncludedExt(name) {
+    return false
+  }
+
+  if !isDir && (runner.minSize != "" || runner.maxSize != "") {
+    info, err := entry.Info()
+
+    if err != nil || !runner.isInSizeRange(info.Size()) {
+      return false
+    }
+  }
+
+  if !isDir && runner.since != "" {
+    info, err := entry.Info()
+
+    if err != nil || !runner.isModifiedSince(info.ModTime()) {
+      return false
+    }
+  }
+
+  return true
+}
+
+// itemURL returns the link to the named item in the current directory. Links
+// are relative unless a base url is configured. Directory links end with a
+// slash, which saves a redirect from most web servers.
+func (ctx *dirContext) itemURL(name string, isDir bool) string {
+  var lnk string
+
+  if !ctx.absoluteLinks() {
+    lnk = relativeURL(name)
+  } else {
+    lnk = ctx.absoluteURL(path.Join(ctx.dirChrooted, name))
+  }
+
+  if isDir {
+    lnk += "/"
+  }
+
+  return lnk
 }
 
-type Breadcrumb struct {
-  Text string
-  Link string
+// absoluteLinks reports whether links should be absolute, which they are
+// with a base url or --absolute-urls. Without a base url, they start at the
+// root of the web server.
+func (runner *RootCmdRunner) absoluteLinks() bool {
+  return runner.baseUrl != "" || runner.absoluteURLs
 }
 
-type DirectoryItem struct {
-  URL string
-  IsDir bool
-  IsSymlink bool
-  Name string
-  Size int64
-  ModTime time.Time
+// absoluteURL joins a slash separated path, relative to the root directory,
+// onto the base url.
+func (runner *RootCmdRunner) absoluteURL(p string) string {
+  return strings.TrimSuffix(runner.baseUrl, "/") + escapeURLPath(path.Join("/", p))
 }
 
-var rootCmdRunner = RootCmdRunner{}
-var rootCmd = &cobra.Command{
-  Use: "indexify <dir>",
-  Version: "1.0.0",
-  RunE: rootCmdRunner.Run,
-  Args: cobra.ExactArgs(1),
+// absoluteDirURL is absoluteURL for a directory, with a trailing slash.
+func (runner *RootCmdRunner) absoluteDirURL(p string) string {
+  lnk := runner.absoluteURL(p)
+
+  if !strings.HasSuffix(lnk, "/") {
+    lnk += "/"
+  }
+
+  return lnk
 }
 
-func Execute() {
-  if err := rootCmd.Execute(); err != nil {
-    fmt.Println(err)
-    os.Exit(1)
+// relativeURL returns a relative link to the slash separated path p.
+func relativeURL(p string) string {
+  lnk := escapeURLPath(p)
+
+  // a colon in the first segment would be mistaken for a url scheme
+  if strings.Contains(strings.SplitN(lnk, "/", 2)[0], ":") {
+    lnk = "./" + lnk
   }
+
+  return lnk
 }
 
-func init() {
-  rootCmd.Flags().StringVarP(
-    &rootCmdRunner.rootRelative,
-    "root", "", "",
-    "path to root directory",
-  )
+// escapeURLPath percent-encodes each segment of the slash separated path p
+// on its own, so that names containing characters such as "?", "#" or an
+// encoded slash survive as a single segment. This is the inverse of how
+// generateBreadcrumbs unescapes each segment.
+func escapeURLPath(p string) string {
+  segments := strings.Split(p, "/")
 
-  rootCmd.Flags().BoolVarP(
-    &rootCmdRunner.includeHidden,
-    "hidden", "", false,
-    "index hidden files",
-  )
+  for i, segment := range segments {
+    segments[i] = url.PathEscape(segment)
+  }
 
-  rootCmd.Flags().BoolVarP(
-    &rootCmdRunner.dryRun,
-    "dry-run", "n", false,
-    "don't write anything to disk",
-  )
+  return strings.Join(segments, "/")
+}
 
-  rootCmd.Flags().BoolVarP(
-    &rootCmdRunner.stdout,
-    "stdout", "", false,
-    "output to stdout only",
-  )
+func (ctx *dirContext) generateBreadcrumbs() {
+  if len(ctx.dirChrooted) == 0 {
+    return
+  }
 
-  rootCmd.Flags().StringVarP(
-    &rootCmdRunner.indexName,
-    "index-name", "", "index.html",
-    "name of index file to generate",
-  )
+  // skip trailing slash
+  lpath := ctx.dirChrooted
+
+  if lpath[len(lpath)-1] == '/' {
+    lpath = lpath[:len(lpath)-1]
+  }
+
+  parts := strings.Split(lpath, "/")
+  result := make([]Breadcrumb, len(parts))
+
+  for i, p := range parts {
+    if i == 0 && p == "" {
+      p = "/"
+    }
+
+    // the directory name could include an encoded slash in its path, so the
+    // item name should be unescaped in the loop rather than unescaping the
+    // entire path outside the loop.
+
+    var lnk string
+
+    if !ctx.absoluteLinks() {
+      lnk = strings.Repeat("../", len(parts)-i-1)
+    } else {
+      lnk = ctx.absoluteDirURL(strings.Join(parts[:i+1], "/"))
+    }
+
+    // an empty link would still lead to the page itself, but isn't obviously
+    // a link to anything
+    if lnk == "" {
+      lnk = "./"
+    }
+
+    // a name that merely looks escaped, such as "100%", is shown as it is
+    if unescaped, err := url.PathUnescape(p); err == nil {
+      p = unescaped
+    }
+
+    result[i] = Breadcrumb{
+      Link: lnk,
+      Text: p,
+      Current: i > 0 && i == len(parts)-1,
+    }
+  }
 
-  rootCmd.MarkFlagRequired("root")
+  ctx.templateData.Breadcrumbs = result
 }
 
-func (runner *RootCmdRunner) Run(cmd *cobra.Command, args []string) error {
+func (ctx *dirContext) render() error {
   var err error
+  var writeIndex func(io.Writer, IndexTemplate) error
 
-  err = runner.parseArgs(args)
+  if ctx.format == "json" {
+    writeIndex = func(w io.Writer, data IndexTemplate) error {
+      return writeJSON(w, ctx.jsonListing(data))
+    }
+  } else {
+    writeIndex = func(w io.Writer, data IndexTemplate) error {
+      if ctx.stream {
+        return ctx.executeStreaming(w, data)
+      }
 
-  if err != nil {
-    return err
+      data.Groups = ctx.groupItems(data.Items)
+
+      if !ctx.minify {
+        return ctx.tmpl.Execute(w, data)
+      }
+
+      buf := new(bytes.Buffer)
+      err := ctx.tmpl.Execute(buf, data)
+
+      if err != nil {
+        return err
+      }
+
+      _, err = io.WriteString(w, minifyHTML(buf.String()))
+      return err
+    }
   }
 
-  err = runner.resolveDirectories()
+  if ctx.collectsStdout() {
+    ctx.collectStdoutListing()
+    return nil
+  }
 
-  if err != nil {
-    return err
+  if ctx.stdout {
+    ctx.countWrite()
+    return writeIndex(os.Stdout, ctx.truncated())
   }
 
-  err = runner.fetchData()
+  pages := ctx.pages()
 
-  if err != nil {
-    return err
+  for _, page := range pages {
+    path := ctx.pagePath(page.PageNum)
+    write := func(w io.Writer) error {
+      return writeIndex(w, page)
+    }
+
+    err = ctx.renderToFile(path, ctx.isGeneratedContent, write)
+
+    if err == nil && ctx.gzip {
+      err = ctx.renderGzip(path, ctx.isGeneratedContent, write)
+    }
+
+    if err != nil {
+      return err
+    }
   }
 
-  runner.generateBreadcrumbs()
-  err = runner.render()
+  if ctx.pageSize > 0 {
+    err = ctx.removeStalePages(len(pages))
+
+    if err != nil {
+      return err
+    }
+  }
 
-  if err != nil && (
-    errors.Is(err, errTargetIsADirectory) ||
-    errors.Is(err, errTargetExistsAndIsNotGenerated)) {
+  if ctx.rss {
+    err = ctx.renderFeed()
 
-    fmt.Printf("skipped: %s\n", err)
-    return nil
+    if err != nil {
+      return err
+    }
   }
 
-  return err
-}
+  if ctx.checksums != "" {
+    return ctx.renderChecksums()
+  }
 
-func (runner *RootCmdRunner) parseArgs(args []string) error {
-  runner.dirRelative = args[0]
   return nil
 }
 
-func (runner *RootCmdRunner) resolveDirectories() error {
-  var err error
+func (runner *RootCmdRunner) parseTemplate() (*template.Template, error) {
+  // the layout comes first, since the first file is the one executed. The
+  // common file only has the partials all the layouts use.
+  if runner.templatePath == "" {
+    return template.ParseFS(
+      embedded, themeTemplates[runner.themeName], commonTemplateName,
+    )
+  }
 
-  runner.dirAbsolute, err = filepath.Abs(runner.dirRelative)
+  t, err := template.ParseFiles(runner.templatePath)
 
   if err != nil {
-    return err
+    return nil, fmt.Errorf("invalid template %s: %w", runner.templatePath, err)
   }
 
-  runner.rootAbsolute, err = filepath.Abs(runner.rootRelative)
+  return t, nil
+}
 
-  if err != nil {
-    return err
+// jsonListing returns the JSON output for data.
+func (runner *RootCmdRunner) jsonListing(data IndexTemplate) jsonIndex {
+  if runner.groupBy != "" {
+    data.Groups = runner.groupItems(data.Items)
   }
 
-  runner.dirRelativeToRoot, err = filepath.Rel(
-    runner.rootAbsolute,
-    runner.dirAbsolute,
-  )
+  // an empty directory is an empty list rather than null, so consumers can
+  // always iterate over it
+  if data.Items == nil {
+    data.Items = []DirectoryItem{}
+  }
 
-  if strings.HasPrefix(runner.dirRelativeToRoot, "..") {
-    return fmt.Errorf("directory is outside root")
+  for i := range data.Groups {
+    if data.Groups[i].Items == nil {
+      data.Groups[i].Items = []DirectoryItem{}
+    }
+  }
+
+  return jsonIndex{
+    Generator: generatorName,
+    IndexTemplate: data,
   }
+}
 
-  runner.dirChrooted = filepath.Join("/", runner.dirRelativeToRoot)
-  runner.templateData.Name = fmt.Sprintf("Index: %s", runner.dirChrooted)
-  runner.templateData.CanGoUp = runner.dirAbsolute != runner.rootAbsolute
+// writeJSON writes v, a listing or an array of them, as indented JSON.
+func writeJSON(w io.Writer, v interface{}) error {
+  enc := json.NewEncoder(w)
+  enc.SetIndent("", "  ")
 
-  return err
+  return enc.Encode(v)
 }
 
-func (runner *RootCmdRunner) fetchData() error {
-  var err error
+// collectsStdout reports whether --stdout covers several directories, whose
+// listings are then written as a single JSON array, rather than one document
+// after another.
+func (runner *RootCmdRunner) collectsStdout() bool {
+  return runner.stdout && (runner.recursive || len(runner.startDirs) > 1)
+}
+
+func (ctx *dirContext) collectStdoutListing() {
+  listing := ctx.jsonListing(ctx.truncated())
+
+  state := ctx.state
+  state.mu.Lock()
+  defer state.mu.Unlock()
+
+  state.stdoutListings = append(state.stdoutListings, listing)
+}
+
+// writeStdoutListings writes the listings collected during the walk. Even an
+// empty run writes an array, so the output always parses.
+func (runner *RootCmdRunner) writeStdoutListings() error {
+  listings := runner.state.stdoutListings
+
+  if listings == nil {
+    listings = []jsonIndex{}
+  }
 
-  files, err := os.ReadDir(runner.dirAbsolute)
+  runner.countWrite()
+  return writeJSON(os.Stdout, listings)
+}
+
+func (runner *RootCmdRunner) renderToFile(
+  path string,
+  isGenerated func(string) bool,
+  write func(io.Writer) error,
+) error {
+  err := runner.checkRenderTarget(path, isGenerated)
 
   if err != nil {
     return err
   }
 
-  for _, dirEntry := range files {
-    info, err := dirEntry.Info()
+  if runner.skipUnchanged {
+    buf := new(bytes.Buffer)
+    err = write(buf)
 
     if err != nil {
       return err
     }
 
-    name := dirEntry.Name()
-
-    if !runner.includeHidden && strings.HasPrefix(name, ".") {
-      continue
+    // leaving the file alone keeps its modification time, so caches and
+    // rsync don't see a change
+    if existing, err := os.ReadFile(path); err == nil && bytes.Equal(existing, buf.Bytes()) {
+      runner.emit(
+        logVerbose,
+        actionEvent{Action: "unchanged", Path: path},
+        "unchanged %s", path,
+      )
+      return nil
     }
 
-    if name == "index.html" {
-      continue
+    write = func(w io.Writer) error {
+      _, err := w.Write(buf.Bytes())
+      return err
     }
+  }
 
-    item := DirectoryItem{
-      URL: name,
-      IsDir: dirEntry.IsDir(),
-      IsSymlink: info.Mode() & fs.ModeSymlink > 0,
-      Name: dirEntry.Name(),
-      Size: info.Size(),
-      ModTime: info.ModTime().UTC(),
-    }
+  runner.logAction("write", path)
+
+  if runner.dryRun {
+    runner.countWrite()
+    return nil
+  }
 
-    runner.templateData.Items = append(runner.templateData.Items, item)
+  if runner.outDir != "" {
+    err = os.MkdirAll(filepath.Dir(path), 0755)
 
-    if dirEntry.IsDir() {
-      runner.templateData.NumDirs += 1
-    } else {
-      runner.templateData.NumFiles += 1
+    if err != nil {
+      return err
     }
   }
 
+  err = writeFileAtomic(path, runner.backup, write)
+
+  if err != nil {
+    return err
+  }
+
+  runner.countWrite()
   return nil
 }
 
-func (runner *RootCmdRunner) generateBreadcrumbs() {
-  if len(runner.dirChrooted) == 0 {
-    return
+// writeFileAtomic writes to a temporary file next to path and only renames it
+// over path once everything was written, so an error or an interruption never
+// leaves a truncated file behind. With backup, an existing file at path is
+// kept as path.bak as well.
+func writeFileAtomic(
+  path string,
+  backup bool,
+  write func(io.Writer) error,
+) error {
+  dir, name := filepath.Split(path)
+  f, err := os.CreateTemp(dir, "." + name + ".tmp*")
+
+  if err != nil {
+    return err
   }
 
-  // skip trailing slash
-  lpath := runner.dirChrooted
+  defer os.Remove(f.Name())
+  err = write(f)
 
-  if lpath[len(lpath)-1] == '/' {
-    lpath = lpath[:len(lpath)-1]
+  if err == nil {
+    err = f.Chmod(0644)
   }
 
-  parts := strings.Split(lpath, "/")
-  result := make([]Breadcrumb, len(parts))
+  if closeErr := f.Close(); err == nil {
+    err = closeErr
+  }
 
-  for i, p := range parts {
-    if i == 0 && p == "" {
-      p = "/"
-    }
+  if err != nil {
+    return err
+  }
 
-    // the directory name could include an encoded slash in its path, so the
-    // item name should be unescaped in the loop rather than unescaping the
-    // entire path outside the loop.
+  if backup {
+    err = backupFile(path)
 
-    p, _ = url.PathUnescape(p)
-    lnk := strings.Repeat("../", len(parts)-i-1)
-    result[i] = Breadcrumb{Link: lnk, Text: p}
+    if err != nil {
+      return err
+    }
   }
 
-  runner.templateData.Breadcrumbs = result
+  return os.Rename(f.Name(), path)
 }
 
-func (runner *RootCmdRunner) render() error {
-  var err error
-
-  t, err := template.ParseFS(embedded, "template.html")
+// backupFile keeps the file at path, if there is one, as path.bak. The backup
+// is a hard link where the file system allows it, or else a copy, so that the
+// file stays in place until the new version is renamed over it.
+func backupFile(path string) error {
+  backupPath := path + backupSuffix
+  err := os.Remove(backupPath)
 
-  if err != nil {
+  if err != nil && !errors.Is(err, fs.ErrNotExist) {
     return err
   }
 
-  if runner.stdout {
-    return t.Execute(os.Stdout, runner.templateData)
+  err = os.Link(path, backupPath)
+
+  if err == nil || errors.Is(err, fs.ErrNotExist) {
+    return nil
   }
 
-  return runner.renderToFile(t)
+  return copyFile(path, backupPath)
 }
 
-func (runner *RootCmdRunner) renderToFile(t *template.Template) error {
-  err := runner.checkRenderTarget()
+func copyFile(src string, dst string) error {
+  in, err := os.Open(src)
 
-  if err != nil {
-    return err
+  if errors.Is(err, fs.ErrNotExist) {
+    return nil
   }
 
-  if runner.dryRun {
-    fmt.Println("[dry-run] write", runner.renderTargetPath())
-    return nil
+  if err != nil {
+    return err
   }
 
-  f, err := os.Create(runner.renderTargetPath())
+  defer in.Close()
+  out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
 
   if err != nil {
     return err
   }
 
-  defer f.Close()
+  _, err = io.Copy(out, in)
+
+  if closeErr := out.Close(); err == nil {
+    err = closeErr
+  }
 
-  return t.Execute(f, runner.templateData)
+  return err
 }
 
-func (runner *RootCmdRunner) checkRenderTarget() error {
-  f, err := os.Open(runner.renderTargetPath())
+// checkRenderTarget makes sure that path is either missing or a file that
+// isGenerated recognizes as one of ours, so hand-written files are never
+// overwritten.
+func (runner *RootCmdRunner) checkRenderTarget(
+  path string,
+  isGenerated func(string) bool,
+) error {
+  f, err := os.Open(path)
 
   if err != nil {
     // failure to open probably meant the file was not found, which is ok
@@ -317,32 +2906,174 @@ func (runner *RootCmdRunner) checkRenderTarget() error {
   }
 
   if info.IsDir() {
-    return fmt.Errorf(
-      "%w: %s", errTargetIsADirectory, runner.renderTargetPath(),
-    )
+    return fmt.Errorf("%w: %s", errTargetIsADirectory, path)
   }
 
   buf := new(bytes.Buffer)
   buf.ReadFrom(f)
   data := buf.String()
 
-  if strings.Contains(data, "Index generated with") {
+  if isGenerated(data) {
     return nil
   }
 
-  return fmt.Errorf(
-    "%w: %s", errTargetExistsAndIsNotGenerated, runner.renderTargetPath(),
+  return fmt.Errorf("%w: %s", errTargetExistsAndIsNotGenerated, path)
+}
+
+func (runner *RootCmdRunner) isGeneratedContent(data string) bool {
+  if runner.format == "json" {
+    return isGeneratedJSON(data)
+  }
+
+  return runner.isGeneratedHTML(data)
+}
+
+func isGeneratedJSON(data string) bool {
+  var index jsonIndex
+  err := json.Unmarshal([]byte(data), &index)
+  return err == nil && index.Generator == generatorName
+}
+
+func (runner *RootCmdRunner) isGeneratedHTML(data string) bool {
+  // the built-in templates always write their own marker, whatever --marker
+  // is set to
+  if runner.templatePath == "" && strings.Contains(data, builtinMarker) {
+    return true
+  }
+
+  return strings.Contains(data, runner.marker)
+}
+
+func (ctx *dirContext) renderTargetPath() string {
+  return filepath.Join(ctx.outputDir(), ctx.renderTargetName())
+}
+
+// outputRoot is where the index of the root directory goes: the root itself,
+// or the --out-dir.
+func (runner *RootCmdRunner) outputRoot() string {
+  if runner.outDir == "" {
+    return runner.rootRelative
+  }
+
+  return runner.outDir
+}
+
+// outputDir is where the files generated for the current directory go. With
+// --out-dir, that's the same place relative to the output directory as the
+// current directory is relative to the root.
+func (ctx *dirContext) outputDir() string {
+  if ctx.outDir == "" {
+    return ctx.dirRelative
+  }
+
+  return filepath.Join(ctx.outDir, ctx.dirRelativeToRoot)
+}
+
+func (runner *RootCmdRunner) renderTargetName() string {
+  if runner.format == "json" {
+    return jsonIndexName(runner.indexName)
+  }
+
+  return runner.indexName
+}
+
+// jsonIndexName returns the name of the --format json index for --index-name
+// name, which has its extension replaced with .json.
+func jsonIndexName(name string) string {
+  return strings.TrimSuffix(name, filepath.Ext(name)) + ".json"
+}
+
+func (it IndexTemplate) HumanTotalSize() string {
+  return humanSize(it.TotalSize, it.SizeUnits)
+}
+
+// GroupColspan returns how many columns a --group-by heading spans, which is
+// all of them except the first.
+func (it IndexTemplate) GroupColspan() int {
+  // name and the empty last column
+  n := 2
+
+  if !it.HideSize {
+    n += 1
+  }
+
+  if !it.HideDate {
+    n += 1
+  }
+
+  if it.ShowPerms {
+    n += 1
+  }
+
+  return n
+}
+
+// HumanTotal describes the counts in words, such as "157 items (12 folders,
+// 145 files)".
+func (it IndexTemplate) HumanTotal() string {
+  return fmt.Sprintf(
+    "%s (%s)", pluralize(it.NumItems, "item", "items"), it.HumanCounts(),
+  )
+}
+
+// HumanCounts describes the number of folders and files, such as "12
+// folders, 145 files".
+func (it IndexTemplate) HumanCounts() string {
+  return fmt.Sprintf(
+    "%s, %s",
+    pluralize(it.NumDirs, "folder", "folders"),
+    pluralize(it.NumFiles, "file", "files"),
   )
 }
 
-func (runner *RootCmdRunner) renderTargetPath() string {
-  return filepath.Join(runner.dirRelative, runner.indexName)
+func pluralize(n int, singular string, plural string) string {
+  if n == 1 {
+    return humanize.Comma(int64(n)) + " " + singular
+  }
+
+  return humanize.Comma(int64(n)) + " " + plural
+}
+
+func (it IndexTemplate) HumanHiddenItemCount() string {
+  return humanize.Comma(int64(it.HiddenItemCount))
 }
 
 func (di *DirectoryItem) HumanModTime(format string) string {
   return di.ModTime.Format(format)
 }
 
+func (di *DirectoryItem) HumanRelativeTime() string {
+  return humanize.Time(di.ModTime)
+}
+
 func (di *DirectoryItem) HumanSize() string {
-  return humanize.IBytes(uint64(di.Size))
+  return humanSize(di.Size, di.SizeUnits)
+}
+
+// DisplaySize is HumanSize for files, but empty for directories unless
+// --compute-dir-sizes is set, since their own size says nothing about their
+// contents.
+func (di *DirectoryItem) DisplaySize() string {
+  if di.IsDir && !di.sizeComputed {
+    return ""
+  }
+
+  return di.HumanSize()
+}
+
+// HumanSizePadded is DisplaySize right-aligned to width characters, for
+// templates that line up sizes in a monospace column. Directories without a
+// size are all spaces, so the columns after them stay aligned too. Sizes
+// wider than width are left as they are.
+func (di *DirectoryItem) HumanSizePadded(width int) string {
+  return fmt.Sprintf("%*s", width, di.DisplaySize())
+}
+
+// humanSize formats size in the --size-units: iec, the default, or si.
+func humanSize(size int64, units string) string {
+  if units == "si" {
+    return humanize.Bytes(uint64(size))
+  }
+
+  return humanize.IBytes(uint64(size))
 }
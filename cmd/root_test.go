@@ -0,0 +1,1078 @@
+package cmd
+
+import (
+  "encoding/json"
+  "io"
+  "os"
+  "path/filepath"
+  "strings"
+  "sync"
+  "testing"
+
+  "github.com/spf13/cobra"
+  "github.com/spf13/pflag"
+)
+
+// runIndexify runs the command line args the way a fresh process would, with
+// every flag back at its default, and returns the error it ended with.
+func runIndexify(t *testing.T, args ...string) error {
+  t.Helper()
+  resetCommand()
+
+  rootCmd.SetArgs(args)
+  rootCmd.SetOut(io.Discard)
+  rootCmd.SetErr(io.Discard)
+
+  return rootCmd.Execute()
+}
+
+// newRunner parses the command line args into the runner without running
+// anything, for tests that drive prepare and execute themselves.
+func newRunner(t *testing.T, args ...string) *RootCmdRunner {
+  t.Helper()
+  resetCommand()
+
+  if err := rootCmd.ParseFlags(args); err != nil {
+    t.Fatal(err)
+  }
+
+  if err := rootCmdRunner.parseArgs(rootCmd.Flags().Args()); err != nil {
+    t.Fatal(err)
+  }
+
+  rootCmdRunner.state = newRunState()
+  return &rootCmdRunner
+}
+
+// resetCommand undoes whatever an earlier run did to the runner and the
+// flags bound to it.
+func resetCommand() {
+  rootCmdRunner = RootCmdRunner{}
+  rootCmd.SilenceUsage = false
+
+  reset := func(f *pflag.Flag) {
+    // slices were cleared along with the runner, and setting the default
+    // would add it as an element
+    if _, ok := f.Value.(pflag.SliceValue); !ok {
+      f.Value.Set(f.DefValue)
+    }
+
+    f.Changed = false
+  }
+
+  for _, c := range append([]*cobra.Command{rootCmd}, rootCmd.Commands()...) {
+    c.Flags().VisitAll(reset)
+    c.PersistentFlags().VisitAll(reset)
+  }
+}
+
+// writeTree creates the files below root, with their contents. Names ending
+// with a slash are created as empty directories.
+func writeTree(t *testing.T, root string, files map[string]string) {
+  t.Helper()
+
+  for name, content := range files {
+    path := filepath.Join(root, filepath.FromSlash(name))
+
+    if strings.HasSuffix(name, "/") {
+      if err := os.MkdirAll(path, 0755); err != nil {
+        t.Fatal(err)
+      }
+
+      continue
+    }
+
+    if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+      t.Fatal(err)
+    }
+
+    if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+      t.Fatal(err)
+    }
+  }
+}
+
+func readFile(t *testing.T, path string) string {
+  t.Helper()
+  data, err := os.ReadFile(path)
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  return string(data)
+}
+
+// readListing reads the index written with --format json to dir.
+func readListing(t *testing.T, dir string) jsonIndex {
+  t.Helper()
+  var index jsonIndex
+
+  err := json.Unmarshal([]byte(readFile(t, filepath.Join(dir, "index.json"))), &index)
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  return index
+}
+
+// runIndexifyStdout is runIndexify for --stdout runs, returning what was
+// written to stdout as well.
+func runIndexifyStdout(t *testing.T, args ...string) (string, error) {
+  t.Helper()
+  r, w, err := os.Pipe()
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  stdout := os.Stdout
+  os.Stdout = w
+  output := make(chan string)
+
+  go func() {
+    data, _ := io.ReadAll(r)
+    output <- string(data)
+  }()
+
+  err = runIndexify(t, args...)
+  os.Stdout = stdout
+  w.Close()
+
+  return <-output, err
+}
+
+// listedDirs returns the directories in the output of a --stdout --format
+// json run over several directories, in the order they were indexed.
+func listedDirs(t *testing.T, output string) []string {
+  t.Helper()
+  var listings []jsonIndex
+
+  if err := json.Unmarshal([]byte(output), &listings); err != nil {
+    t.Fatal(err)
+  }
+
+  dirs := []string{}
+
+  // the default title is the directory
+  for _, listing := range listings {
+    dirs = append(dirs, strings.TrimPrefix(listing.Name, "Index: "))
+  }
+
+  return dirs
+}
+
+// chdir changes the working directory to dir for the rest of the test.
+func chdir(t *testing.T, dir string) {
+  t.Helper()
+  wd, err := os.Getwd()
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  if err := os.Chdir(dir); err != nil {
+    t.Fatal(err)
+  }
+
+  t.Cleanup(func() {
+    os.Chdir(wd)
+  })
+}
+
+func itemNames(items []DirectoryItem) []string {
+  names := []string{}
+
+  for _, item := range items {
+    names = append(names, item.Name)
+  }
+
+  return names
+}
+
+func TestJSONEmptyDirectoryHasItemList(t *testing.T) {
+  root := t.TempDir()
+  err := runIndexify(t, "-q", "--root", root, "--format", "json", root)
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  data := readFile(t, filepath.Join(root, "index.json"))
+
+  if !strings.Contains(data, `"items": []`) {
+    t.Errorf("expected an empty items list, got:\n%s", data)
+  }
+}
+
+func TestCustomMarkerRegeneratesBuiltinTemplate(t *testing.T) {
+  root := t.TempDir()
+  writeTree(t, root, map[string]string{"a.txt": "a"})
+
+  for _, name := range []string{"b.txt", "c.txt"} {
+    err := runIndexify(t, "-q", "--root", root, "--marker", "MYMARK", root)
+
+    if err != nil {
+      t.Fatal(err)
+    }
+
+    writeTree(t, root, map[string]string{name: name})
+  }
+
+  if !strings.Contains(readFile(t, filepath.Join(root, "index.html")), "b.txt") {
+    t.Error("the index from the first run was not regenerated")
+  }
+}
+
+func TestCustomMarkerWithCustomTemplate(t *testing.T) {
+  root := t.TempDir()
+  tmpl := filepath.Join(t.TempDir(), "custom.html")
+
+  writeTree(t, root, map[string]string{
+    "a/index.html": "hand-written, Index generated with nothing",
+    "b/": "",
+  })
+
+  err := os.WriteFile(tmpl, []byte("MYMARK {{.Name}}"), 0644)
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  for i := 0; i < 2; i++ {
+    err = runIndexify(
+      t, "-q", "-r", "--root", root, "--template", tmpl, "--marker", "MYMARK", root,
+    )
+
+    if err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  if got := readFile(t, filepath.Join(root, "a", "index.html")); !strings.HasPrefix(got, "hand-written") {
+    t.Errorf("a file without the marker was overwritten: %s", got)
+  }
+
+  if got := readFile(t, filepath.Join(root, "b", "index.html")); got != "MYMARK Index: /b" {
+    t.Errorf("unexpected index: %s", got)
+  }
+}
+
+func TestBackupKeepsFileInPlace(t *testing.T) {
+  path := filepath.Join(t.TempDir(), "index.html")
+  writeTree(t, filepath.Dir(path), map[string]string{"index.html": "old"})
+
+  // the index has to stay until the new version replaces it
+  if err := backupFile(path); err != nil {
+    t.Fatal(err)
+  }
+
+  if got := readFile(t, path); got != "old" {
+    t.Errorf("expected the file to stay in place, got %q", got)
+  }
+
+  err := writeFileAtomic(path, true, func(w io.Writer) error {
+    _, err := io.WriteString(w, "new")
+    return err
+  })
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  if got := readFile(t, path); got != "new" {
+    t.Errorf("expected the new version, got %q", got)
+  }
+
+  if got := readFile(t, path + backupSuffix); got != "old" {
+    t.Errorf("expected the old version as the backup, got %q", got)
+  }
+}
+
+func TestBackupWithoutExistingFile(t *testing.T) {
+  path := filepath.Join(t.TempDir(), "index.html")
+
+  if err := backupFile(path); err != nil {
+    t.Fatal(err)
+  }
+
+  if _, err := os.Lstat(path + backupSuffix); err == nil {
+    t.Error("expected no backup of a file that doesn't exist")
+  }
+}
+
+func TestBreadcrumbsWithBaseURL(t *testing.T) {
+  tests := []struct {
+    baseURL string
+    want []Breadcrumb
+  }{
+    {"https://example.com/files/", []Breadcrumb{
+      {Text: "/", Link: "https://example.com/files/"},
+      {Text: "a", Link: "https://example.com/files/a/"},
+      {Text: "b c", Link: "https://example.com/files/a/b%20c/", Current: true},
+    }},
+    {"", []Breadcrumb{
+      {Text: "/", Link: "../../"},
+      {Text: "a", Link: "../"},
+      {Text: "b c", Link: "./", Current: true},
+    }},
+  }
+
+  for _, tt := range tests {
+    root := t.TempDir()
+    dir := filepath.Join(root, "a", "b c")
+    writeTree(t, root, map[string]string{"a/b c/": ""})
+
+    err := runIndexify(
+      t, "-q", "--root", root, "--format", "json", "--base-url", tt.baseURL, dir,
+    )
+
+    if err != nil {
+      t.Fatal(err)
+    }
+
+    checkBreadcrumbs(t, readListing(t, dir).Breadcrumbs, tt.want)
+  }
+}
+
+func checkBreadcrumbs(t *testing.T, got []Breadcrumb, want []Breadcrumb) {
+  t.Helper()
+
+  if len(got) != len(want) {
+    t.Fatalf("expected %v, got %v", want, got)
+  }
+
+  for i := range want {
+    if got[i] != want[i] {
+      t.Errorf("breadcrumb %d: expected %v, got %v", i, want[i], got[i])
+    }
+  }
+}
+
+func TestSizeUnits(t *testing.T) {
+  tests := []struct {
+    size int64
+    units string
+    want string
+  }{
+    {999, "si", "999 B"},
+    {1000, "si", "1.0 kB"},
+    {1024, "si", "1.0 kB"},
+    {1000, "iec", "1000 B"},
+    {1023, "iec", "1023 B"},
+    {1024, "iec", "1.0 KiB"},
+    {1000000, "si", "1.0 MB"},
+    {1048576, "iec", "1.0 MiB"},
+  }
+
+  for _, tt := range tests {
+    item := DirectoryItem{Size: tt.size, SizeUnits: tt.units}
+
+    if got := item.HumanSize(); got != tt.want {
+      t.Errorf("%d bytes in %s: expected %q, got %q", tt.size, tt.units, tt.want, got)
+    }
+  }
+}
+
+func TestHumanSizePadded(t *testing.T) {
+  tests := []struct {
+    item DirectoryItem
+    want string
+  }{
+    {DirectoryItem{Size: 5}, "       5 B"},
+    {DirectoryItem{Size: 4096}, "   4.0 KiB"},
+    {DirectoryItem{Size: 3 << 20}, "   3.0 MiB"},
+    {DirectoryItem{Size: 1500 << 30}, "   1.5 TiB"},
+    {DirectoryItem{Size: 4096, IsDir: true}, "          "},
+    {DirectoryItem{Size: 4096, IsDir: true, sizeComputed: true}, "   4.0 KiB"},
+    {DirectoryItem{Size: 1000, SizeUnits: "si"}, "    1.0 kB"},
+  }
+
+  for _, tt := range tests {
+    if got := tt.item.HumanSizePadded(10); got != tt.want {
+      t.Errorf("%d: expected %q, got %q", tt.item.Size, tt.want, got)
+    }
+  }
+
+  // a size that doesn't fit isn't cut short
+  item := DirectoryItem{Size: 4096}
+
+  if got := item.HumanSizePadded(3); got != "4.0 KiB" {
+    t.Errorf("expected the size as is, got %q", got)
+  }
+}
+
+func TestSizeUnitsFlag(t *testing.T) {
+  root := t.TempDir()
+  writeTree(t, root, map[string]string{"a": strings.Repeat("x", 1000)})
+
+  for units, want := range map[string]string{"si": "1.0 kB", "iec": "1000 B"} {
+    if err := runIndexify(t, "-q", "--root", root, "--size-units", units, root); err != nil {
+      t.Fatal(err)
+    }
+
+    if !strings.Contains(readFile(t, filepath.Join(root, "index.html")), want) {
+      t.Errorf("expected %q in the index with --size-units %s", want, units)
+    }
+  }
+}
+
+func TestNoRobots(t *testing.T) {
+  const meta = `<meta name="robots" content="noindex,nofollow">`
+
+  for _, themeName := range []string{"table", "grid"} {
+    for _, noRobots := range []bool{false, true} {
+      root := t.TempDir()
+      args := []string{"-q", "--root", root, "--theme-name", themeName}
+
+      if noRobots {
+        args = append(args, "--no-robots")
+      }
+
+      if err := runIndexify(t, append(args, root)...); err != nil {
+        t.Fatal(err)
+      }
+
+      index := readFile(t, filepath.Join(root, "index.html"))
+
+      if strings.Contains(index, meta) != noRobots {
+        t.Errorf("%s theme with --no-robots=%v: robots meta tag shown: %v",
+          themeName, noRobots, !noRobots)
+      }
+    }
+  }
+}
+
+func TestLayoutsShareCommonParts(t *testing.T) {
+  tests := []struct {
+    themeName string
+    own []string
+  }{
+    {"table", []string{`<table aria-describedby="summary">`, "#up-arrow,\n#down-arrow {", "window.sortByName"}},
+    {"grid", []string{`<ul class="grid">`, ".card:hover {\n  background-color: #252525;", "box-sizing: border-box;"}},
+  }
+
+  // from the common partials: the meta tags, the dark theme, the scripts for
+  // filtering and previews, and the footer with the marker
+  common := []string{
+    `<meta property="og:title" content="Index: /">`,
+    "background-color: #101010;",
+    "document.querySelectorAll('.file')",
+    "document.querySelectorAll('[data-preview] a')",
+    `<div id="preview" hidden></div>`,
+    builtinMarker + ` <a rel="noopener noreferrer" href="https://github.com/veyh/indexify">indexify</a>`,
+  }
+
+  for _, tt := range tests {
+    root := t.TempDir()
+    writeTree(t, root, map[string]string{"a.txt": "a"})
+
+    err := runIndexify(
+      t, "-q", "--root", root, "--theme-name", tt.themeName, "--theme", "dark",
+      "--og-tags", "--preview", root,
+    )
+
+    if err != nil {
+      t.Fatal(err)
+    }
+
+    page := readFile(t, filepath.Join(root, "index.html"))
+
+    for _, want := range append(common, tt.own...) {
+      if !strings.Contains(page, want) {
+        t.Errorf("%s layout: expected %q", tt.themeName, want)
+      }
+    }
+
+    // the filter and the preview find the rows by these
+    if !strings.Contains(page, `file" data-mime="text/plain" data-preview="text">`) {
+      t.Errorf("%s layout: expected a row that the scripts can find", tt.themeName)
+    }
+
+    if strings.Count(page, "<footer>") != 1 || !strings.HasSuffix(strings.TrimSpace(page), "</html>") {
+      t.Errorf("%s layout: expected a single complete page", tt.themeName)
+    }
+  }
+}
+
+func TestIndexNameHtm(t *testing.T) {
+  root := t.TempDir()
+  writeTree(t, root, map[string]string{
+    "a.txt": "a",
+    "b.txt": "b",
+    "sub/index.htm": "hand-written",
+  })
+
+  args := []string{
+    "-q", "-r", "--root", root, "--index-name", "index.htm", "--page-size", "1",
+    "--gzip", "--sitemap", "--base-url", "https://example.com", root,
+  }
+
+  // the second run regenerates the indexes of the first
+  for i := 0; i < 2; i++ {
+    if err := runIndexify(t, args...); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  want := []string{
+    "a.txt", "b.txt", "index-2.htm", "index-2.htm.gz", "index-3.htm",
+    "index-3.htm.gz", "index.htm", "index.htm.gz", "sitemap.xml", "sub/index.htm",
+  }
+
+  if got := listFiles(t, root); !equalStrings(got, want) {
+    t.Errorf("expected %v, got %v", want, got)
+  }
+
+  if got := readFile(t, filepath.Join(root, "sub", "index.htm")); got != "hand-written" {
+    t.Errorf("a hand-written index.htm was overwritten: %s", got)
+  }
+
+  index := readFile(t, filepath.Join(root, "index.htm"))
+
+  if !strings.Contains(index, `href="https://example.com/index-2.htm"`) {
+    t.Errorf("expected a link to the next page:\n%s", index)
+  }
+
+  if strings.Contains(index, `/index.htm"`) {
+    t.Errorf("expected the index not to list itself:\n%s", index)
+  }
+
+  // web servers serve index.htm for the directory itself
+  sitemap := readFile(t, filepath.Join(root, sitemapName))
+
+  if !strings.Contains(sitemap, "<loc>https://example.com/</loc>") {
+    t.Errorf("unexpected sitemap:\n%s", sitemap)
+  }
+}
+
+func TestIndexNameHtmJSON(t *testing.T) {
+  root := t.TempDir()
+  writeTree(t, root, map[string]string{"a.txt": "a"})
+
+  err := runIndexify(
+    t, "-q", "--root", root, "--index-name", "index.htm", "--format", "json", root,
+  )
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  if got := itemNames(readListing(t, root).Items); !equalStrings(got, []string{"a.txt"}) {
+    t.Errorf("expected only a.txt to be listed, got %v", got)
+  }
+}
+
+func TestAbsoluteURLsDeeplyNested(t *testing.T) {
+  for _, baseURL := range []string{"", "/static"} {
+    root := t.TempDir()
+    dir := filepath.Join(root, "a", "b", "c", "d")
+    writeTree(t, root, map[string]string{"a/b/c/d/file.txt": "x", "a/b/c/d/sub/": ""})
+
+    err := runIndexify(
+      t, "-q", "--root", root, "--format", "json", "--absolute-urls",
+      "--base-url", baseURL, dir,
+    )
+
+    if err != nil {
+      t.Fatal(err)
+    }
+
+    listing := readListing(t, dir)
+    urls := map[string]string{}
+
+    for _, item := range listing.Items {
+      urls[item.Name] = item.URL
+    }
+
+    if urls["file.txt"] != baseURL + "/a/b/c/d/file.txt" ||
+      urls["sub"] != baseURL + "/a/b/c/d/sub/" {
+      t.Errorf("unexpected item urls with base url %q: %v", baseURL, urls)
+    }
+
+    if listing.ParentURL != baseURL + "/a/b/c/" {
+      t.Errorf("unexpected parent url with base url %q: %s", baseURL, listing.ParentURL)
+    }
+
+    checkBreadcrumbs(t, listing.Breadcrumbs, []Breadcrumb{
+      {Text: "/", Link: baseURL + "/"},
+      {Text: "a", Link: baseURL + "/a/"},
+      {Text: "b", Link: baseURL + "/a/b/"},
+      {Text: "c", Link: baseURL + "/a/b/c/"},
+      {Text: "d", Link: baseURL + "/a/b/c/d/", Current: true},
+    })
+  }
+}
+
+func TestDefaultsToCurrentDirectory(t *testing.T) {
+  root := t.TempDir()
+  writeTree(t, root, map[string]string{"a.txt": "a", "sub/b.txt": "b"})
+  chdir(t, filepath.Join(root, "sub"))
+
+  if err := runIndexify(t, "-q", "--root", "..", "--format", "json"); err != nil {
+    t.Fatal(err)
+  }
+
+  listing := readListing(t, filepath.Join(root, "sub"))
+
+  if got := itemNames(listing.Items); !equalStrings(got, []string{"b.txt"}) {
+    t.Errorf("expected the current directory to be indexed, got %v", got)
+  }
+
+  if !listing.CanGoUp {
+    t.Error("expected a link up to the root")
+  }
+
+  if _, err := os.Stat(filepath.Join(root, "index.json")); err == nil {
+    t.Error("expected only the current directory to be indexed")
+  }
+}
+
+func TestHiddenStartDirectory(t *testing.T) {
+  root := t.TempDir()
+  public := filepath.Join(root, ".public")
+
+  writeTree(t, root, map[string]string{
+    ".public/a.txt": "a",
+    ".public/sub/b.txt": "b",
+    ".public/.private/c.txt": "c",
+  })
+
+  if err := runIndexify(t, "-q", "-r", "--root", public, "--format", "json", public); err != nil {
+    t.Fatal(err)
+  }
+
+  if got := itemNames(readListing(t, public).Items); !equalStrings(got, []string{"a.txt", "sub"}) {
+    t.Errorf("expected the hidden start directory to be indexed, got %v", got)
+  }
+
+  if got := itemNames(readListing(t, filepath.Join(public, "sub")).Items); !equalStrings(got, []string{"b.txt"}) {
+    t.Errorf("expected the walk to descend below the start directory, got %v", got)
+  }
+
+  if _, err := os.Stat(filepath.Join(public, ".private", "index.json")); err == nil {
+    t.Error("expected the hidden subdirectory to be skipped")
+  }
+}
+
+func TestTrailingSlashes(t *testing.T) {
+  root := t.TempDir()
+  writeTree(t, root, map[string]string{"sub/": ""})
+
+  tests := []struct {
+    dir string
+    canGoUp bool
+    chrooted string
+  }{
+    {"", false, "/"},
+    {"sub", true, "/sub"},
+  }
+
+  for _, tt := range tests {
+    for _, rootSlash := range []string{"", "/", "//"} {
+      for _, dirSlash := range []string{"", "/", "//"} {
+        dir := filepath.Join(root, tt.dir) + dirSlash
+        runner := newRunner(t, "--root", root + rootSlash, dir)
+        ctx, err := runner.prepare(dir)
+
+        if err != nil {
+          t.Fatal(err)
+        }
+
+        if ctx.templateData.CanGoUp != tt.canGoUp {
+          t.Errorf("--root %q %q: expected CanGoUp %v", root + rootSlash, dir, tt.canGoUp)
+        }
+
+        if ctx.dirChrooted != tt.chrooted {
+          t.Errorf("--root %q %q: expected %s, got %s", root + rootSlash, dir, tt.chrooted, ctx.dirChrooted)
+        }
+      }
+    }
+  }
+}
+
+func TestConcurrentExecute(t *testing.T) {
+  root := t.TempDir()
+  dirs := []string{"a", "b", "c", "d"}
+
+  for _, dir := range dirs {
+    writeTree(t, root, map[string]string{
+      dir + "/" + dir + "1.txt": dir,
+      dir + "/" + dir + "2.txt": dir,
+    })
+  }
+
+  runner := newRunner(
+    t, "-q", "--root", root, "--format", "json", "--sitemap",
+    "--base-url", "https://example.com", root,
+  )
+
+  var wg sync.WaitGroup
+  contexts := make([]*dirContext, len(dirs))
+  errs := make([]error, len(dirs))
+
+  for i, dir := range dirs {
+    wg.Add(1)
+
+    go func(i int, dir string) {
+      defer wg.Done()
+      contexts[i], errs[i] = runner.prepare(filepath.Join(root, dir))
+
+      if errs[i] == nil {
+        errs[i] = contexts[i].execute()
+      }
+    }(i, dir)
+  }
+
+  wg.Wait()
+
+  for i, dir := range dirs {
+    if errs[i] != nil {
+      t.Fatal(errs[i])
+    }
+
+    data := contexts[i].templateData
+    want := []string{dir + "1.txt", dir + "2.txt"}
+
+    if data.Name != "Index: /" + dir || !equalStrings(itemNames(data.Items), want) {
+      t.Errorf("%s: got %s with %v", dir, data.Name, itemNames(data.Items))
+    }
+
+    if got := itemNames(readListing(t, filepath.Join(root, dir)).Items); !equalStrings(got, want) {
+      t.Errorf("%s: wrote %v", dir, got)
+    }
+  }
+
+  if n := len(runner.state.sitemapEntries); n != len(dirs) {
+    t.Errorf("expected %d sitemap entries, got %d", len(dirs), n)
+  }
+}
+
+func TestUpLink(t *testing.T) {
+  root := t.TempDir()
+  dir := filepath.Join(root, "a", "b")
+  writeTree(t, root, map[string]string{"a/b/": ""})
+
+  if err := runIndexify(t, "-q", "--root", root, "--up-label", "Back to parent", dir); err != nil {
+    t.Fatal(err)
+  }
+
+  page := readFile(t, filepath.Join(dir, "index.html"))
+
+  if !strings.Contains(page, `<span class="goup">Back to parent</span>`) {
+    t.Error("expected the up link with its custom label")
+  }
+
+  if err := runIndexify(t, "-q", "-r", "--root", root, "--no-up-link", root); err != nil {
+    t.Fatal(err)
+  }
+
+  for _, d := range []string{filepath.Join(root, "a"), dir} {
+    if page := readFile(t, filepath.Join(d, "index.html")); strings.Contains(page, `class="goup"`) {
+      t.Errorf("%s: expected no up link with --no-up-link", d)
+    }
+
+    if err := runIndexify(t, "-q", "--root", root, "--no-up-link", "--format", "json", d); err != nil {
+      t.Fatal(err)
+    }
+
+    if readListing(t, d).CanGoUp {
+      t.Errorf("%s: expected CanGoUp to be false with --no-up-link", d)
+    }
+  }
+}
+
+func TestExcludePath(t *testing.T) {
+  root := t.TempDir()
+
+  writeTree(t, root, map[string]string{
+    "private/p.txt": "p",
+    "a/a.txt": "a",
+    "a/private/q.txt": "q",
+    "a/private/deeper/r.txt": "r",
+    "a/keep/k.txt": "k",
+    "b/private/s.txt": "s",
+  })
+
+  chdir(t, root)
+
+  err := runIndexify(
+    t, "-q", "-r", "--root", ".", "--format", "json",
+    "--exclude-path", filepath.Join(root, "private"),
+    "--exclude-path", "a/private",
+    ".",
+  )
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  for _, dir := range []string{"private", "a/private", "a/private/deeper"} {
+    if _, err := os.Stat(filepath.Join(root, dir, "index.json")); err == nil {
+      t.Errorf("expected %s to be excluded", dir)
+    }
+  }
+
+  for _, dir := range []string{".", "a", "a/keep", "b", "b/private"} {
+    if _, err := os.Stat(filepath.Join(root, dir, "index.json")); err != nil {
+      t.Errorf("expected %s to be indexed", dir)
+    }
+  }
+
+  // only indexing is prevented, the directory is still listed in its parent
+  if got := itemNames(readListing(t, filepath.Join(root, "a")).Items); !equalStrings(got, []string{"a.txt", "keep", "private"}) {
+    t.Errorf("expected the excluded directory to be listed, got %v", got)
+  }
+}
+
+func TestValidateBaseURL(t *testing.T) {
+  valid := []string{
+    "",
+    "https://example.com",
+    "https://example.com/files/",
+    "http://localhost:8080/files",
+    "/",
+    "/static",
+  }
+
+  for _, baseURL := range valid {
+    if err := validateBaseURL(baseURL); err != nil {
+      t.Errorf("%q: %v", baseURL, err)
+    }
+  }
+
+  invalid := []string{
+    "htp://example.com",
+    "https://",
+    "https:///files",
+    "https://example.com ",
+    " /static",
+    "example.com/files",
+    "static",
+    "https://example.com/?a=b",
+    "https://example.com/#top",
+    "https://exa mple.com",
+  }
+
+  for _, baseURL := range invalid {
+    if err := validateBaseURL(baseURL); err == nil {
+      t.Errorf("%q: expected an error", baseURL)
+    }
+  }
+}
+
+func TestInvalidBaseURLFailsEarly(t *testing.T) {
+  root := t.TempDir()
+  err := runIndexify(t, "-q", "--root", root, "--base-url", "htp://example.com", root)
+
+  if err == nil || !strings.Contains(err.Error(), "invalid base url") {
+    t.Fatalf("expected an invalid base url error, got %v", err)
+  }
+
+  if _, err := os.Stat(filepath.Join(root, "index.html")); err == nil {
+    t.Error("expected nothing to be written")
+  }
+}
+
+func TestOnlyDirs(t *testing.T) {
+  root := t.TempDir()
+
+  writeTree(t, root, map[string]string{
+    "a.txt": "a",
+    "docs/b.txt": "b",
+    "docs/deep/c.txt": "c",
+    "music/": "",
+    ".hidden/": "",
+    "node_modules/": "",
+  })
+
+  err := runIndexify(t, "-q", "-r", "--root", root, "--only-dirs", "--exclude", "node_modules", root)
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  page := readFile(t, filepath.Join(root, "index.html"))
+
+  for _, name := range []string{"docs/", "music/"} {
+    if !strings.Contains(page, `href="` + name + `"`) {
+      t.Errorf("expected a row for %s", name)
+    }
+  }
+
+  for _, name := range []string{"a.txt", ".hidden/", "node_modules/"} {
+    if strings.Contains(page, `href="` + name + `"`) {
+      t.Errorf("expected no row for %s", name)
+    }
+  }
+
+  if err := runIndexify(t, "-q", "-r", "--root", root, "--only-dirs", "--format", "json", root); err != nil {
+    t.Fatal(err)
+  }
+
+  listing := readListing(t, root)
+
+  if listing.NumFiles != 0 || listing.NumDirs != 3 {
+    t.Errorf("expected 3 directories and no files, got %d and %d", listing.NumDirs, listing.NumFiles)
+  }
+
+  // the leaf directories are still indexed, with nothing to list
+  if got := itemNames(readListing(t, filepath.Join(root, "docs")).Items); !equalStrings(got, []string{"deep"}) {
+    t.Errorf("expected only the subdirectory, got %v", got)
+  }
+
+  if listing := readListing(t, filepath.Join(root, "docs", "deep")); len(listing.Items) != 0 {
+    t.Errorf("expected the leaf directory to be indexed without files, got %v", itemNames(listing.Items))
+  }
+}
+
+func TestBreadcrumbs(t *testing.T) {
+  tests := []struct {
+    dir string
+    baseURL string
+    want []Breadcrumb
+  }{
+    {"", "", []Breadcrumb{
+      {Text: "/", Link: "./"},
+    }},
+    {"", "https://example.com/files", []Breadcrumb{
+      {Text: "/", Link: "https://example.com/files/"},
+    }},
+    {"sub", "", []Breadcrumb{
+      {Text: "/", Link: "../"},
+      {Text: "sub", Link: "./", Current: true},
+    }},
+    {"a%2Fb", "", []Breadcrumb{
+      {Text: "/", Link: "../"},
+      {Text: "a/b", Link: "./", Current: true},
+    }},
+    {"a%2Fb", "https://example.com/files", []Breadcrumb{
+      {Text: "/", Link: "https://example.com/files/"},
+      {Text: "a/b", Link: "https://example.com/files/a%252Fb/", Current: true},
+    }},
+  }
+
+  for _, tt := range tests {
+    root := t.TempDir()
+    dir := filepath.Join(root, tt.dir)
+    writeTree(t, root, map[string]string{tt.dir + "/": ""})
+
+    err := runIndexify(
+      t, "-q", "--root", root, "--format", "json", "--base-url", tt.baseURL, dir,
+    )
+
+    if err != nil {
+      t.Fatal(err)
+    }
+
+    checkBreadcrumbs(t, readListing(t, dir).Breadcrumbs, tt.want)
+  }
+}
+
+func TestBreadcrumbsRendered(t *testing.T) {
+  root := t.TempDir()
+  dir := filepath.Join(root, "sub")
+  writeTree(t, root, map[string]string{"sub/": ""})
+
+  if err := runIndexify(t, "-q", "-r", "--root", root, root); err != nil {
+    t.Fatal(err)
+  }
+
+  // the root crumb is a link even on the root's own page
+  if page := readFile(t, filepath.Join(root, "index.html")); !strings.Contains(page, `<a href="./">/</a>`) {
+    t.Error("expected the root breadcrumb to be a link")
+  }
+
+  page := readFile(t, filepath.Join(dir, "index.html"))
+
+  if !strings.Contains(page, `<a href="../">/</a>`) {
+    t.Error("expected the root breadcrumb to link up")
+  }
+
+  if !strings.Contains(page, `<span class="current">sub</span>`) || strings.Contains(page, `">sub</a>`) {
+    t.Error("expected the current directory to be plain text")
+  }
+}
+
+// executeIndexify is runIndexify for the whole command line, returning the
+// exit status.
+func executeIndexify(t *testing.T, args ...string) int {
+  t.Helper()
+  resetCommand()
+
+  rootCmd.SetArgs(args)
+  rootCmd.SetOut(io.Discard)
+  rootCmd.SetErr(io.Discard)
+
+  return execute()
+}
+
+func TestExitStatus(t *testing.T) {
+  root := t.TempDir()
+  writeTree(t, root, map[string]string{"a.txt": "a", "sub/b.txt": "b"})
+  index := filepath.Join(root, "sub", "index.html")
+
+  tests := []struct {
+    name string
+    args []string
+    want int
+  }{
+    {"first run", []string{"-r", "--skip-unchanged"}, exitOK},
+    {"unchanged", []string{"-r", "--skip-unchanged"}, exitNothingWritten},
+    {"rewritten", []string{"-r"}, exitOK},
+    {"dry run", []string{"-r", "--dry-run"}, exitOK},
+    {"unchanged dry run", []string{"-r", "--skip-unchanged", "--dry-run"}, exitNothingWritten},
+    {"json", []string{"-r", "--skip-unchanged", "--format", "json"}, exitOK},
+    {"checksums", []string{"--checksums", "sha256"}, exitOK},
+    {"checksums match", []string{"--checksums", "sha256", "--checksum-verify"}, exitOK},
+    {"invalid flag", []string{"--jobs", "0"}, exitError},
+    {"outside root", []string{"-r", filepath.Dir(root)}, exitError},
+  }
+
+  for _, tt := range tests {
+    args := append([]string{"-q", "--root", root}, tt.args...)
+
+    if tt.name != "outside root" {
+      args = append(args, root)
+    }
+
+    if got := executeIndexify(t, args...); got != tt.want {
+      t.Errorf("%s: expected exit status %d, got %d", tt.name, tt.want, got)
+    }
+  }
+
+  // a hand-written index is skipped, which leaves nothing to write
+  if err := os.Remove(filepath.Join(root, "index.html")); err != nil {
+    t.Fatal(err)
+  }
+
+  if err := os.WriteFile(index, []byte("hand-written"), 0644); err != nil {
+    t.Fatal(err)
+  }
+
+  if got := executeIndexify(t, "-q", "--root", root, filepath.Join(root, "sub")); got != exitNothingWritten {
+    t.Errorf("skipped: expected exit status %d, got %d", exitNothingWritten, got)
+  }
+
+  if got := executeIndexify(t, "clean", "-q", "--root", root, root); got != exitOK {
+    t.Errorf("clean: expected exit status %d, got %d", exitOK, got)
+  }
+}
+
+func TestSummaryCountsChangedFiles(t *testing.T) {
+  root := t.TempDir()
+  writeTree(t, root, map[string]string{"a.txt": "a", "sub/b.txt": "b", "sub/deep/c.txt": "c"})
+
+  for _, want := range []string{"3 files written", "0 files written"} {
+    output, err := runIndexifyStdout(t, "-r", "--skip-unchanged", "--root", root, root)
+
+    if err != nil {
+      t.Fatal(err)
+    }
+
+    if !strings.Contains(output, want + ", 0 skipped, 3 files indexed, 0 errors") {
+      t.Errorf("expected %q in the summary, got %q", want, output)
+    }
+  }
+}
@@ -0,0 +1,125 @@
+package cmd
+
+import (
+  "encoding/xml"
+  "io"
+  "os"
+  "path"
+  "path/filepath"
+  "sort"
+  "strings"
+  "time"
+)
+
+const sitemapName = "sitemap.xml"
+const sitemapMarker = "<!-- Sitemap generated with indexify -->"
+
+type sitemapURLSet struct {
+  XMLName xml.Name `xml:"urlset"`
+  XMLNS string `xml:"xmlns,attr"`
+  URLs []sitemapEntry `xml:"url"`
+}
+
+type sitemapEntry struct {
+  Loc string `xml:"loc"`
+  LastMod string `xml:"lastmod"`
+}
+
+// addSitemapEntry records the index that was just generated for the current
+// directory, so that renderSitemap can list it once the whole run is done.
+func (ctx *dirContext) addSitemapEntry() error {
+  info, err := os.Stat(ctx.dirAbsolute)
+
+  if err != nil {
+    return err
+  }
+
+  loc := ctx.indexURL()
+  state := ctx.state
+  state.mu.Lock()
+  defer state.mu.Unlock()
+
+  // keyed by url, so that regenerating a directory with --watch replaces its
+  // entry instead of adding another one
+  state.sitemapEntries[loc] = sitemapEntry{
+    Loc: loc,
+    LastMod: ctx.clampModTime(info.ModTime()).UTC().Format(time.RFC3339),
+  }
+
+  return nil
+}
+
+// indexURL returns the full url of the current directory's index, for the
+// sitemap and the feed. Web servers serve the usual directory index names for
+// the bare directory url, other names have to be linked explicitly.
+func (ctx *dirContext) indexURL() string {
+  loc := ctx.absoluteURL(
+    path.Join(ctx.dirChrooted, ctx.renderTargetName()),
+  )
+
+  if isDirectoryIndexName(ctx.renderTargetName()) {
+    loc = strings.TrimSuffix(loc, ctx.renderTargetName())
+  }
+
+  return loc
+}
+
+// directoryIndexNames are the file names web servers commonly serve for a
+// directory url by default.
+var directoryIndexNames = []string{"index.html", "index.htm"}
+
+func isDirectoryIndexName(name string) bool {
+  for _, indexName := range directoryIndexNames {
+    if name == indexName {
+      return true
+    }
+  }
+
+  return false
+}
+
+func (runner *RootCmdRunner) renderSitemap() error {
+  return runner.renderToFile(
+    filepath.Join(runner.outputRoot(), sitemapName),
+    isGeneratedSitemap,
+    runner.writeSitemap,
+  )
+}
+
+func (runner *RootCmdRunner) writeSitemap(w io.Writer) error {
+  entries := make([]sitemapEntry, 0, len(runner.state.sitemapEntries))
+
+  for _, entry := range runner.state.sitemapEntries {
+    entries = append(entries, entry)
+  }
+
+  sort.Slice(entries, func(i, j int) bool {
+    return entries[i].Loc < entries[j].Loc
+  })
+
+  urlSet := sitemapURLSet{
+    XMLNS: "http://www.sitemaps.org/schemas/sitemap/0.9",
+    URLs: entries,
+  }
+
+  _, err := io.WriteString(w, xml.Header + sitemapMarker + "\n")
+
+  if err != nil {
+    return err
+  }
+
+  enc := xml.NewEncoder(w)
+  enc.Indent("", "  ")
+  err = enc.Encode(urlSet)
+
+  if err != nil {
+    return err
+  }
+
+  _, err = io.WriteString(w, "\n")
+  return err
+}
+
+func isGeneratedSitemap(data string) bool {
+  return strings.Contains(data, sitemapMarker)
+}
@@ -0,0 +1,202 @@
+package cmd
+
+import (
+  "sort"
+  "strings"
+
+  "golang.org/x/text/collate"
+)
+
+var validSortKeys = []string{"name", "natural", "size", "date", "type"}
+
+func isValidSortKey(key string) bool {
+  for _, k := range validSortKeys {
+    if k == key {
+      return true
+    }
+  }
+
+  return false
+}
+
+// sortItems orders the items by the selected sort key. The sort is stable so
+// that items comparing equal keep the (name sorted) order os.ReadDir returned
+// them in. --reverse flips whatever key is active.
+func (ctx *dirContext) sortItems() {
+  items := ctx.templateData.Items
+  less := ctx.itemLess()
+
+  sort.SliceStable(items, func(i, j int) bool {
+    return less(&items[i], &items[j])
+  })
+
+  if ctx.dirsFirst {
+    partitionDirsFirst(items)
+  }
+}
+
+// itemLess returns the comparison for the selected sort key, flipped with
+// --reverse. --dirs-first is applied separately.
+func (ctx *dirContext) itemLess() func(a, b *DirectoryItem) bool {
+  byName := lessByName
+
+  // a collator isn't safe for concurrent use, so each sort gets its own
+  if ctx.collate != "" {
+    byName = lessByCollation(collate.New(ctx.collateTag))
+  }
+
+  less := itemLessFunc(ctx.sortBy, byName)
+
+  if ctx.reverse {
+    return func(a, b *DirectoryItem) bool {
+      return less(b, a)
+    }
+  }
+
+  return less
+}
+
+// partitionDirsFirst moves directories in front of files while keeping the
+// relative order within each group, so --reverse applies per group.
+func partitionDirsFirst(items []DirectoryItem) {
+  sort.SliceStable(items, func(i, j int) bool {
+    return items[i].IsDir && !items[j].IsDir
+  })
+}
+
+// itemLessFunc returns the comparison for key. Items that are equal by key
+// are ordered by byName, which is also what the name key itself uses.
+func itemLessFunc(
+  key string,
+  byName func(a, b *DirectoryItem) bool,
+) func(a, b *DirectoryItem) bool {
+  switch key {
+  case "natural":
+    return func(a, b *DirectoryItem) bool {
+      if c := compareNatural(a.Name, b.Name); c != 0 {
+        return c < 0
+      }
+
+      return byName(a, b)
+    }
+
+  case "size":
+    return func(a, b *DirectoryItem) bool {
+      if a.Size != b.Size {
+        return a.Size < b.Size
+      }
+
+      return byName(a, b)
+    }
+
+  case "date":
+    return func(a, b *DirectoryItem) bool {
+      if !a.ModTime.Equal(b.ModTime) {
+        return a.ModTime.Before(b.ModTime)
+      }
+
+      return byName(a, b)
+    }
+
+  case "type":
+    return func(a, b *DirectoryItem) bool {
+      if a.IsDir != b.IsDir {
+        return a.IsDir
+      }
+
+      return byName(a, b)
+    }
+  }
+
+  return byName
+}
+
+// lessByName compares names case-insensitively, so that "apple" and "Zebra"
+// interleave sensibly. Names differing only by case fall back to a byte-wise
+// comparison to keep the order deterministic.
+func lessByName(a, b *DirectoryItem) bool {
+  la := strings.ToLower(a.Name)
+  lb := strings.ToLower(b.Name)
+
+  if la != lb {
+    return la < lb
+  }
+
+  return a.Name < b.Name
+}
+
+// lessByCollation compares names by the rules of a language with --collate,
+// so that accented letters sort next to their base letters, e.g. "école"
+// before "zèbre". Names the collator considers equal fall back to lessByName.
+func lessByCollation(c *collate.Collator) func(a, b *DirectoryItem) bool {
+  return func(a, b *DirectoryItem) bool {
+    if r := c.CompareString(a.Name, b.Name); r != 0 {
+      return r < 0
+    }
+
+    return lessByName(a, b)
+  }
+}
+
+// compareNatural compares names so that runs of digits are ordered by their
+// numeric value, e.g. "img9" < "img10" and "v1.2.9" < "v1.2.10". The other
+// parts of the name are compared case-insensitively. Names that compare equal
+// this way (such as "007" and "7") fall back to the name order.
+func compareNatural(a, b string) int {
+  a = strings.ToLower(a)
+  b = strings.ToLower(b)
+
+  for len(a) > 0 && len(b) > 0 {
+    if isDigit(a[0]) && isDigit(b[0]) {
+      var na, nb string
+      na, a = splitDigits(a)
+      nb, b = splitDigits(b)
+
+      if c := compareNumeric(na, nb); c != 0 {
+        return c
+      }
+
+      continue
+    }
+
+    if a[0] != b[0] {
+      if a[0] < b[0] {
+        return -1
+      }
+
+      return 1
+    }
+
+    a = a[1:]
+    b = b[1:]
+  }
+
+  return len(a) - len(b)
+}
+
+func isDigit(c byte) bool {
+  return c >= '0' && c <= '9'
+}
+
+func splitDigits(s string) (string, string) {
+  i := 0
+
+  for i < len(s) && isDigit(s[i]) {
+    i++
+  }
+
+  return s[:i], s[i:]
+}
+
+// compareNumeric compares two runs of digits by value without converting them
+// to integers, so arbitrarily long runs work. Leading zeros are ignored.
+func compareNumeric(a, b string) int {
+  a = strings.TrimLeft(a, "0")
+  b = strings.TrimLeft(b, "0")
+
+  if len(a) != len(b) {
+    return len(a) - len(b)
+  }
+
+  return strings.Compare(a, b)
+}
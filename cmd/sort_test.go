@@ -0,0 +1,59 @@
+package cmd
+
+import (
+  "testing"
+)
+
+func TestCollation(t *testing.T) {
+  names := map[string]string{
+    "zebra": "", "Zoo": "", "éclair": "", "eclipse": "", "Émile": "",
+    "apple": "", "Äpfel": "", "banana": "",
+  }
+
+  tests := []struct {
+    collate string
+    want []string
+  }{
+    // without a locale, accented letters sort after z
+    {"", []string{"apple", "banana", "eclipse", "zebra", "Zoo", "Äpfel", "éclair", "Émile"}},
+    {"en", []string{"Äpfel", "apple", "banana", "éclair", "eclipse", "Émile", "zebra", "Zoo"}},
+    // in Swedish, ä is a letter of its own after z
+    {"sv", []string{"apple", "banana", "éclair", "eclipse", "Émile", "zebra", "Zoo", "Äpfel"}},
+  }
+
+  for _, tt := range tests {
+    root := t.TempDir()
+    writeTree(t, root, names)
+
+    err := runIndexify(
+      t, "-q", "--root", root, "--format", "json", "--collate", tt.collate, root,
+    )
+
+    if err != nil {
+      t.Fatal(err)
+    }
+
+    if got := itemNames(readListing(t, root).Items); !equalStrings(got, tt.want) {
+      t.Errorf("--collate %q: expected %v, got %v", tt.collate, tt.want, got)
+    }
+  }
+}
+
+func TestCollationReverse(t *testing.T) {
+  root := t.TempDir()
+  writeTree(t, root, map[string]string{"b": "", "á": "", "c": ""})
+
+  err := runIndexify(
+    t, "-q", "--root", root, "--format", "json", "--collate", "en", "--reverse", root,
+  )
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  want := []string{"c", "b", "á"}
+
+  if got := itemNames(readListing(t, root).Items); !equalStrings(got, want) {
+    t.Errorf("expected %v, got %v", want, got)
+  }
+}
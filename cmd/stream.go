@@ -0,0 +1,196 @@
+package cmd
+
+import (
+  "fmt"
+  "io"
+  "io/fs"
+  "sort"
+  "time"
+)
+
+// streamSortKeys are the --sort keys that only need the names of the entries
+// and whether they are directories, so --stream can sort the entries before
+// reading anything else about them.
+var streamSortKeys = []string{"name", "natural", "type"}
+
+// streamedEntries is what --stream keeps of a directory between counting its
+// entries and writing them out. The items are only made as they're written.
+type streamedEntries struct {
+  entries []listedEntry
+  ignored []string
+  gitTimes map[string]time.Time
+}
+
+type listedEntry struct {
+  entry fs.DirEntry
+  isDir bool
+}
+
+// checkStream rejects the flags that need all of a directory's items at once,
+// which is what --stream avoids.
+func (runner *RootCmdRunner) checkStream() error {
+  if runner.format != "html" {
+    return fmt.Errorf("--stream requires --format html")
+  }
+
+  sortable := false
+
+  for _, key := range streamSortKeys {
+    if key == runner.sortBy {
+      sortable = true
+    }
+  }
+
+  if !sortable {
+    return fmt.Errorf("--stream can only sort by name, natural or type")
+  }
+
+  conflicts := []struct {
+    flag string
+    set bool
+  }{
+    {"--group-by", runner.groupBy != ""},
+    {"--page-size", runner.pageSize > 0},
+    {"--rss", runner.rss},
+    {"--checksums", runner.checksums != ""},
+    {"--compute-dir-sizes", runner.computeDirSizes},
+    {"--minify", runner.minify},
+    {"--skip-unchanged", runner.skipUnchanged},
+    {"--two-pass", runner.twoPass},
+    {"--summary-page", runner.summaryPage},
+    {"--template", runner.templatePath != ""},
+  }
+
+  for _, conflict := range conflicts {
+    if conflict.set {
+      return fmt.Errorf("--stream cannot be combined with %s", conflict.flag)
+    }
+  }
+
+  return nil
+}
+
+// fetchCounts is fetchData for --stream. It counts the entries that are
+// listed and puts them in order, but leaves making their items to
+// streamItems.
+func (ctx *dirContext) fetchCounts() error {
+  files, ignored, gitTimes, err := ctx.readEntries()
+
+  if err != nil {
+    return err
+  }
+
+  var listed []listedEntry
+
+  for _, dirEntry := range files {
+    item, _, ok, err := ctx.newItem(dirEntry, ignored, gitTimes)
+
+    if err != nil {
+      return err
+    }
+
+    if !ok {
+      continue
+    }
+
+    listed = append(listed, listedEntry{entry: dirEntry, isDir: item.IsDir})
+    ctx.countItem(item)
+  }
+
+  // the sort keys --stream allows only look at these two fields
+  less := ctx.itemLess()
+
+  sort.SliceStable(listed, func(i, j int) bool {
+    a := DirectoryItem{Name: listed[i].entry.Name(), IsDir: listed[i].isDir}
+    b := DirectoryItem{Name: listed[j].entry.Name(), IsDir: listed[j].isDir}
+    return less(&a, &b)
+  })
+
+  if ctx.dirsFirst {
+    sort.SliceStable(listed, func(i, j int) bool {
+      return listed[i].isDir && !listed[j].isDir
+    })
+  }
+
+  ctx.streamed = &streamedEntries{
+    entries: listed,
+    ignored: ignored,
+    gitTimes: gitTimes,
+  }
+
+  ctx.templateData.CountedChildren = ctx.countChildren
+
+  if ctx.readme {
+    ctx.templateData.Readme, err = ctx.readReadme(ignored)
+
+    if err != nil {
+      return err
+    }
+  }
+
+  return nil
+}
+
+// streamItems makes the items of the listed entries and sends them to items
+// one at a time, up to --max-items.
+func (ctx *dirContext) streamItems(items chan<- *DirectoryItem) error {
+  for i, listed := range ctx.streamed.entries {
+    if ctx.maxItems > 0 && i >= ctx.maxItems {
+      break
+    }
+
+    item, info, ok, err := ctx.newItem(
+      listed.entry, ctx.streamed.ignored, ctx.streamed.gitTimes,
+    )
+
+    if err != nil {
+      return err
+    }
+
+    // the entry changed since it was counted
+    if !ok {
+      continue
+    }
+
+    err = ctx.completeItem(&item, info)
+
+    if err != nil {
+      return err
+    }
+
+    items <- &item
+  }
+
+  return nil
+}
+
+// executeStreaming executes the template for --stream, with the items made
+// while it's being written instead of beforehand.
+func (ctx *dirContext) executeStreaming(w io.Writer, data IndexTemplate) error {
+  if ctx.maxItems > 0 && data.NumItems > ctx.maxItems {
+    data.HiddenItemCount = data.NumItems - ctx.maxItems
+  }
+
+  items := make(chan *DirectoryItem)
+  var streamErr error
+
+  go func() {
+    defer close(items)
+    streamErr = ctx.streamItems(items)
+  }()
+
+  data.Groups = []ItemGroup{{stream: items}}
+  err := ctx.tmpl.Execute(w, data)
+
+  // if the template stopped halfway, the rest of the items still have to be
+  // taken for the goroutine to finish. Once the channel is closed, streamErr
+  // is set.
+  for range items {
+  }
+
+  if err != nil {
+    return err
+  }
+
+  return streamErr
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+  "errors"
+  "fmt"
+  "sort"
+  "strings"
+  "time"
+)
+
+// runStats accumulates what happened during a run, for the summary printed
+// at the end of a recursive run.
+type runStats struct {
+  // files actually written, or that would be with --dry-run, which leaves
+  // out unchanged files and skipped directories. The exit code is based on
+  // this as well.
+  written int
+  skipped map[string]int
+  files int
+  errors int
+
+  // only kept for --summary-page
+  tree treeTotals
+  topLevel map[string]*treeTotals
+}
+
+func (runner *RootCmdRunner) countFiles(numFiles int) {
+  state := runner.state
+  state.mu.Lock()
+  defer state.mu.Unlock()
+
+  state.stats.files += numFiles
+}
+
+func (runner *RootCmdRunner) countWrite() {
+  state := runner.state
+  state.mu.Lock()
+  defer state.mu.Unlock()
+
+  state.stats.written += 1
+}
+
+func (runner *RootCmdRunner) countSkipped(reason string) {
+  state := runner.state
+  state.mu.Lock()
+  defer state.mu.Unlock()
+
+  if state.stats.skipped == nil {
+    state.stats.skipped = map[string]int{}
+  }
+
+  state.stats.skipped[reason] += 1
+}
+
+func (runner *RootCmdRunner) countError() {
+  state := runner.state
+  state.mu.Lock()
+  defer state.mu.Unlock()
+
+  state.stats.errors += 1
+}
+
+// skipReason returns a short description of why isSkipError(err) is true.
+func skipReason(err error) string {
+  if errors.Is(err, errTargetIsADirectory) {
+    return "target is a directory"
+  }
+
+  return "not generated"
+}
+
+func (runner *RootCmdRunner) printSummary(elapsed time.Duration) {
+  stats := runner.state.stats
+  numSkipped := 0
+  var reasons []string
+
+  for reason, n := range stats.skipped {
+    numSkipped += n
+    reasons = append(reasons, fmt.Sprintf("%d %s", n, reason))
+  }
+
+  sort.Strings(reasons)
+  skippedByReason := stats.skipped
+
+  if skippedByReason == nil {
+    skippedByReason = map[string]int{}
+  }
+
+  skipped := fmt.Sprintf("%d skipped", numSkipped)
+
+  if len(reasons) > 0 {
+    skipped += fmt.Sprintf(" (%s)", strings.Join(reasons, ", "))
+  }
+
+  runner.emit(
+    logNormal,
+    summaryEvent{
+      Action: "summary",
+      Written: stats.written,
+      Skipped: skippedByReason,
+      Files: stats.files,
+      Errors: stats.errors,
+      Seconds: elapsed.Seconds(),
+    },
+    "%s written, %s, %d files indexed, %d errors in %s",
+    pluralize(stats.written, "file", "files"),
+    skipped,
+    stats.files,
+    stats.errors,
+    elapsed.Round(time.Millisecond),
+  )
+}
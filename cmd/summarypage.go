@@ -0,0 +1,142 @@
+package cmd
+
+import (
+  "html/template"
+  "io"
+  "path"
+  "path/filepath"
+  "sort"
+  "strings"
+)
+
+// summaryPageName is the page --summary-page writes to the root directory.
+const summaryPageName = "summary.html"
+// summaryPageMarker is in the footer, since html/template drops comments.
+const summaryPageMarker = "Summary generated with"
+
+// SummaryTemplate is what the summary page is rendered with: the totals for
+// the whole tree, and for each of the top-level directories.
+type SummaryTemplate struct {
+  Name string
+  NumDirs int
+  NumFiles int
+  TotalSize int64
+  SizeUnits string
+  Dirs []SummaryDir
+}
+
+type SummaryDir struct {
+  Name string
+  URL string
+  NumDirs int
+  NumFiles int
+  TotalSize int64
+  SizeUnits string
+}
+
+// treeTotals accumulates the counts of the directories below one top-level
+// directory, or of the whole tree.
+type treeTotals struct {
+  dirs int
+  files int
+  size int64
+}
+
+func (totals *treeTotals) add(dirs int, files int, size int64) {
+  totals.dirs += dirs
+  totals.files += files
+  totals.size += size
+}
+
+// countIndexed adds the current directory to the totals of the summary page.
+// Directories are counted where they are indexed, so only files count here.
+func (ctx *dirContext) countIndexed() {
+  var size int64
+
+  for _, item := range ctx.templateData.Items {
+    if !item.IsDir && !item.IsSymlink {
+      size += item.Size
+    }
+  }
+
+  files := ctx.templateData.NumFiles
+  topLevel, _, _ := strings.Cut(filepath.ToSlash(ctx.dirRelativeToRoot), "/")
+
+  state := ctx.state
+  state.mu.Lock()
+  defer state.mu.Unlock()
+
+  state.stats.tree.add(1, files, size)
+
+  if topLevel == "." {
+    return
+  }
+
+  if state.stats.topLevel == nil {
+    state.stats.topLevel = map[string]*treeTotals{}
+  }
+
+  if state.stats.topLevel[topLevel] == nil {
+    state.stats.topLevel[topLevel] = &treeTotals{}
+  }
+
+  state.stats.topLevel[topLevel].add(1, files, size)
+}
+
+func (runner *RootCmdRunner) renderSummaryPage() error {
+  t, err := template.ParseFS(embedded, summaryPageName)
+
+  if err != nil {
+    return err
+  }
+
+  stats := runner.state.stats
+  data := SummaryTemplate{
+    Name: "Summary",
+    NumDirs: stats.tree.dirs,
+    NumFiles: stats.tree.files,
+    TotalSize: stats.tree.size,
+    SizeUnits: runner.sizeUnits,
+  }
+
+  for name, totals := range stats.topLevel {
+    lnk := relativeURL(name) + "/"
+
+    if runner.absoluteLinks() {
+      lnk = runner.absoluteDirURL(path.Join("/", name))
+    }
+
+    data.Dirs = append(data.Dirs, SummaryDir{
+      Name: name,
+      URL: lnk,
+      NumDirs: totals.dirs,
+      NumFiles: totals.files,
+      TotalSize: totals.size,
+      SizeUnits: runner.sizeUnits,
+    })
+  }
+
+  sort.Slice(data.Dirs, func(i, j int) bool {
+    return strings.ToLower(data.Dirs[i].Name) < strings.ToLower(data.Dirs[j].Name)
+  })
+
+  return runner.renderToFile(
+    filepath.Join(runner.outputRoot(), summaryPageName),
+    isGeneratedSummaryPage,
+    func(w io.Writer) error {
+      return t.Execute(w, data)
+    },
+  )
+}
+
+func isGeneratedSummaryPage(data string) bool {
+  return strings.Contains(data, summaryPageMarker)
+}
+
+func (s SummaryTemplate) HumanTotalSize() string {
+  return humanSize(s.TotalSize, s.SizeUnits)
+}
+
+func (d SummaryDir) HumanTotalSize() string {
+  return humanSize(d.TotalSize, d.SizeUnits)
+}
@@ -0,0 +1,154 @@
+package cmd
+
+import (
+  "errors"
+  "io/fs"
+  "os"
+  "path/filepath"
+  "strings"
+)
+
+// visitedDirs is the set of the real paths of the directories a walk has been
+// into, so that it doesn't go into any of them twice, however many symlinks
+// lead there.
+type visitedDirs map[string]bool
+
+// add records the directory at path and reports whether it's new to the set.
+// A path that can't be resolved is always new, so that whatever walks it runs
+// into the error itself.
+func (visited visitedDirs) add(path string) bool {
+  real, err := realPath(path)
+
+  if err != nil {
+    return true
+  }
+
+  if visited[real] {
+    return false
+  }
+
+  visited[real] = true
+  return true
+}
+
+// walkSubtree calls fn for dir and everything below it, like filepath.WalkDir,
+// and is what anything that walks a tree and might follow symlinks should use.
+// With follow, a symlink is passed to fn as it is and, if it leads to a
+// directory and fn doesn't return filepath.SkipDir for it, the directory is
+// walked after the rest of the tree, with paths going through the link. No
+// directory in visited is walked again, so a link to an ancestor, or to a
+// directory the walk has been into already, ends there. Directories that fn
+// skips count as visited as well.
+func walkSubtree(
+  dir string,
+  follow bool,
+  visited visitedDirs,
+  fn fs.WalkDirFunc,
+) error {
+  var links []string
+
+  err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+    if err == nil && d.IsDir() && !visited.add(path) {
+      return filepath.SkipDir
+    }
+
+    err = fn(path, d, err)
+
+    if d == nil || d.Type() & fs.ModeSymlink == 0 {
+      return err
+    }
+
+    // a symlink is a file as far as WalkDir is concerned, and SkipDir would
+    // skip the rest of its directory
+    if errors.Is(err, filepath.SkipDir) {
+      return nil
+    }
+
+    if err == nil && follow {
+      links = append(links, path)
+    }
+
+    return err
+  })
+
+  if err != nil {
+    return err
+  }
+
+  for _, link := range links {
+    if !isFollowableDirLink(link) {
+      continue
+    }
+
+    // the trailing separator makes WalkDir descend into the link's target
+    // instead of reporting the link itself
+    err = walkSubtree(link + string(filepath.Separator), follow, visited, fn)
+
+    if err != nil {
+      return err
+    }
+  }
+
+  return nil
+}
+
+// realPath returns the absolute path of path with every symlink in it
+// resolved, so that a directory has the same real path however it's reached.
+func realPath(path string) (string, error) {
+  abs, err := filepath.Abs(path)
+
+  if err != nil {
+    return "", err
+  }
+
+  return filepath.EvalSymlinks(abs)
+}
+
+// isFollowableDirLink reports whether a walk can descend into the symlink at
+// path. It has to point to a directory, and following it mustn't lead back
+// into a directory the walk is already in, even one above where the walk
+// started, which isn't in its visited set.
+func isFollowableDirLink(path string) bool {
+  info, err := os.Stat(path)
+
+  // broken links and links to files have nothing to walk
+  if err != nil || !info.IsDir() {
+    return false
+  }
+
+  target, err := realPath(path)
+
+  if err != nil {
+    return false
+  }
+
+  return !isSymlinkLoop(path, target)
+}
+
+// isSymlinkLoop reports whether following the link at path, which resolves to
+// the real path target, would lead back into a directory that is already
+// being walked. That's the case if target is, or contains, any directory the
+// link sits in, whether the directory was reached through links or not.
+func isSymlinkLoop(path string, target string) bool {
+  abs, err := filepath.Abs(path)
+
+  if err != nil {
+    return true
+  }
+
+  for dir := filepath.Dir(abs); ; dir = filepath.Dir(dir) {
+    real, err := filepath.EvalSymlinks(dir)
+
+    if err != nil {
+      return true
+    }
+
+    if real == target || strings.HasPrefix(real, target + string(filepath.Separator)) {
+      return true
+    }
+
+    if filepath.Dir(dir) == dir {
+      return false
+    }
+  }
+}
@@ -0,0 +1,148 @@
+package cmd
+
+import (
+  "io/fs"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+func TestFollowSymlinksStopsAtLinkToAncestor(t *testing.T) {
+  root := t.TempDir()
+  writeTree(t, root, map[string]string{"a/file.txt": "a"})
+
+  if err := os.Symlink("..", filepath.Join(root, "a", "up")); err != nil {
+    t.Fatal(err)
+  }
+
+  // relative paths, as they usually are on the command line, resolve to
+  // relative link targets
+  chdir(t, root)
+  output, err := runIndexifyStdout(
+    t, "-r", "--follow-symlinks", "--root", ".", "--stdout", "--format", "json", ".",
+  )
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  want := []string{"/", "/a"}
+
+  if got := listedDirs(t, output); !equalStrings(got, want) {
+    t.Errorf("expected %v to be indexed, got %v", want, got)
+  }
+}
+
+func TestFollowSymlinksIndexesEachDirectoryOnce(t *testing.T) {
+  root := t.TempDir()
+  outside := t.TempDir()
+
+  writeTree(t, root, map[string]string{"a/": "", "b/": "", "real/x.txt": "x"})
+  writeTree(t, outside, map[string]string{"y.txt": "y"})
+
+  links := map[string]string{
+    "a/inside": filepath.Join(root, "real"),
+    "b/inside": filepath.Join(root, "real"),
+    "a/outside": outside,
+    "b/outside": outside,
+  }
+
+  for name, target := range links {
+    if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  chdir(t, root)
+  output, err := runIndexifyStdout(
+    t, "-r", "--follow-symlinks", "--root", ".", "--stdout", "--format", "json", ".",
+  )
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  // real directories are walked before any links to them, and a directory
+  // outside the tree is walked through whichever link comes first
+  want := []string{"/", "/a", "/b", "/real", "/a/outside"}
+
+  if got := listedDirs(t, output); !equalStrings(got, want) {
+    t.Errorf("expected %v to be indexed, got %v", want, got)
+  }
+
+  // with --jobs, indexing a directory twice would write the same files at
+  // the same time
+  if err := runIndexify(t, "-q", "-r", "--follow-symlinks", "--jobs", "4", "--root", ".", "."); err != nil {
+    t.Fatal(err)
+  }
+}
+
+func TestDirSizesAndCountsStopAtLinkToAncestor(t *testing.T) {
+  root := t.TempDir()
+  writeTree(t, root, map[string]string{"a/file.txt": "abc", "a/b/file.txt": "de"})
+
+  if err := os.Symlink("..", filepath.Join(root, "a", "b", "up")); err != nil {
+    t.Fatal(err)
+  }
+
+  chdir(t, root)
+
+  err := runIndexify(
+    t, "-q", "-r", "--follow-symlinks", "--compute-dir-sizes", "--count-children",
+    "--root", ".", "--format", "json", ".",
+  )
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  items := readListing(t, root).Items
+
+  if len(items) != 1 || items[0].Name != "a" {
+    t.Fatalf("expected only a, got %v", itemNames(items))
+  }
+
+  if items[0].Size != 5 {
+    t.Errorf("expected the size of a to leave out the loop, got %d", items[0].Size)
+  }
+
+  if items[0].ChildCount != 2 {
+    t.Errorf("expected a to have 2 entries, got %d", items[0].ChildCount)
+  }
+}
+
+func TestWalkSubtreeWalksEachDirectoryOnce(t *testing.T) {
+  root := t.TempDir()
+  writeTree(t, root, map[string]string{"a/b/": "", "c/": ""})
+
+  for name, target := range map[string]string{"a/b/up": "../..", "c/a": "../a", "c/self": "."} {
+    if err := os.Symlink(target, filepath.Join(root, filepath.FromSlash(name))); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  var dirs []string
+
+  err := walkSubtree(root, true, visitedDirs{}, func(path string, d fs.DirEntry, err error) error {
+    if err != nil {
+      return err
+    }
+
+    if d.IsDir() {
+      rel, _ := filepath.Rel(root, path)
+      dirs = append(dirs, filepath.ToSlash(rel))
+    }
+
+    return nil
+  })
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  want := []string{".", "a", "a/b", "c"}
+
+  if !equalStrings(dirs, want) {
+    t.Errorf("expected %v, got %v", want, dirs)
+  }
+}
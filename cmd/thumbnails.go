@@ -0,0 +1,117 @@
+package cmd
+
+import (
+  "errors"
+  "image"
+  _ "image/gif"
+  "image/jpeg"
+  "image/png"
+  "io"
+  "io/fs"
+  "os"
+  "path"
+  "path/filepath"
+  "strings"
+  "time"
+
+  "github.com/nfnt/resize"
+)
+
+// thumbsDirName is the directory, next to the index, that --thumbnails are
+// written to. Each thumbnail has the same name as its image.
+const thumbsDirName = ".thumbs"
+
+// thumbnailSize is the maximum width and height of a thumbnail in pixels.
+const thumbnailSize = 128
+
+// isThumbnailable reports whether --thumbnails can make a thumbnail of the
+// file called name, going by its extension.
+func isThumbnailable(name string) bool {
+  switch strings.ToLower(filepath.Ext(name)) {
+  case ".jpg", ".jpeg", ".png", ".gif":
+    return true
+  }
+
+  return false
+}
+
+// thumbnail makes sure there's an up to date thumbnail of the named image in
+// the current directory and returns its url. Images that can't be decoded
+// get no thumbnail rather than failing the listing.
+func (ctx *dirContext) thumbnail(name string, modTime time.Time) (string, error) {
+  thumbPath := filepath.Join(ctx.outputDir(), thumbsDirName, name)
+  lnk := relativeURL(path.Join(thumbsDirName, name))
+
+  if ctx.absoluteLinks() {
+    lnk = ctx.absoluteURL(path.Join(ctx.dirChrooted, thumbsDirName, name))
+  }
+
+  info, err := os.Stat(thumbPath)
+
+  if err == nil && !info.ModTime().Before(modTime) {
+    return lnk, nil
+  }
+
+  if err != nil && !errors.Is(err, fs.ErrNotExist) {
+    return "", err
+  }
+
+  // --stdout doesn't write anything to disk, so only thumbnails that are up
+  // to date already are shown
+  if ctx.stdout {
+    return "", nil
+  }
+
+  img, err := decodeImage(ctx.fsys, ctx.itemPath(name))
+
+  if err != nil {
+    ctx.logf(logVerbose, "no thumbnail for %s: %v", name, err)
+    return "", nil
+  }
+
+  ctx.logAction("write", thumbPath)
+
+  if ctx.dryRun {
+    ctx.countWrite()
+    return lnk, nil
+  }
+
+  err = os.MkdirAll(filepath.Dir(thumbPath), 0755)
+
+  if err != nil {
+    return "", err
+  }
+
+  thumb := resize.Thumbnail(thumbnailSize, thumbnailSize, img, resize.Lanczos3)
+
+  err = writeFileAtomic(thumbPath, false, func(w io.Writer) error {
+    ext := strings.ToLower(filepath.Ext(name))
+
+    if ext == ".jpg" || ext == ".jpeg" {
+      return jpeg.Encode(w, thumb, nil)
+    }
+
+    // gif thumbnails are saved as png, which keeps the transparency
+    return png.Encode(w, thumb)
+  })
+
+  if err != nil {
+    return "", err
+  }
+
+  ctx.countWrite()
+  return lnk, nil
+}
+
+func decodeImage(fsys fs.FS, name string) (image.Image, error) {
+  f, err := fsys.Open(name)
+
+  if err != nil {
+    return nil, err
+  }
+
+  defer f.Close()
+
+  img, _, err := image.Decode(f)
+  return img, err
+}
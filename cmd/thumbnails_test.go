@@ -0,0 +1,70 @@
+package cmd
+
+import (
+  "bytes"
+  "image"
+  "image/png"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+func writePNG(t *testing.T, path string) {
+  t.Helper()
+  var buf bytes.Buffer
+
+  if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 300, 200))); err != nil {
+    t.Fatal(err)
+  }
+
+  if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+    t.Fatal(err)
+  }
+}
+
+func TestThumbnails(t *testing.T) {
+  root := t.TempDir()
+  writePNG(t, filepath.Join(root, "a.png"))
+
+  if err := runIndexify(t, "-q", "--root", root, "--format", "json", "--thumbnails", root); err != nil {
+    t.Fatal(err)
+  }
+
+  thumb, err := os.Open(filepath.Join(root, thumbsDirName, "a.png"))
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  defer thumb.Close()
+  config, err := png.DecodeConfig(thumb)
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  if config.Width != thumbnailSize || config.Height > thumbnailSize {
+    t.Errorf("unexpected thumbnail size %dx%d", config.Width, config.Height)
+  }
+
+  if url := readListing(t, root).Items[0].ThumbURL; url != ".thumbs/a.png" {
+    t.Errorf("unexpected thumbnail url: %s", url)
+  }
+}
+
+func TestThumbnailsWithoutWriting(t *testing.T) {
+  for _, flag := range []string{"--stdout", "--dry-run"} {
+    root := t.TempDir()
+    writePNG(t, filepath.Join(root, "a.png"))
+
+    _, err := runIndexifyStdout(t, "-q", "--root", root, "--thumbnails", flag, root)
+
+    if err != nil {
+      t.Fatal(err)
+    }
+
+    if _, err := os.Stat(filepath.Join(root, thumbsDirName)); err == nil {
+      t.Errorf("thumbnails were written with %s", flag)
+    }
+  }
+}
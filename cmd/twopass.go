@@ -0,0 +1,142 @@
+package cmd
+
+import (
+  "path/filepath"
+)
+
+// processTwoPass indexes the tree for --two-pass. The first pass fetches the
+// data of every directory, then the subdirectory counts and sizes are filled
+// in from the listings of the subdirectories, and only then is anything
+// written. The first pass doesn't read the subdirectories that are indexed
+// themselves, so every directory is only read once.
+func (runner *RootCmdRunner) processTwoPass() error {
+  dirs, err := runner.collectDirs()
+
+  if err != nil {
+    return err
+  }
+
+  runner.state.progress.setTotal(len(dirs))
+  listed := map[string]bool{}
+
+  for _, dir := range dirs {
+    if runner.skipRoot && runner.isStartDir(dir) {
+      continue
+    }
+
+    absolute, err := filepath.Abs(dir)
+
+    if err != nil {
+      return err
+    }
+
+    listed[absolute] = true
+  }
+
+  // each worker writes to a different element, so no locking is needed. A
+  // directory that is skipped or fails stays nil.
+  contexts := make([]*dirContext, len(dirs))
+
+  err = runner.inParallel(len(dirs), func(i int) error {
+    if runner.skipRoot && runner.isStartDir(dirs[i]) {
+      runner.logSkip("starting directory", dirs[i])
+      return nil
+    }
+
+    ctx, err := runner.prepare(dirs[i])
+
+    if err != nil {
+      return err
+    }
+
+    ctx.listedDirs = listed
+    err = ctx.fetchData()
+
+    if err != nil {
+      return err
+    }
+
+    contexts[i] = ctx
+    return nil
+  })
+
+  if err != nil {
+    return err
+  }
+
+  byDir := map[string]*dirContext{}
+
+  for _, ctx := range contexts {
+    if ctx != nil {
+      byDir[ctx.dirAbsolute] = ctx
+    }
+  }
+
+  // the walk visits parents before their children, so going backwards every
+  // subdirectory is complete by the time its parent looks at it
+  for i := len(contexts) - 1; i >= 0; i-- {
+    if contexts[i] != nil {
+      contexts[i].useChildListings(byDir)
+    }
+  }
+
+  return runner.inParallel(len(dirs), func(i int) error {
+    defer runner.state.progress.add()
+
+    if contexts[i] == nil {
+      return nil
+    }
+
+    return contexts[i].publish()
+  })
+}
+
+// useChildListings fills in the counts and sizes of subdirectories, which
+// fetchData left out, from their own listings in byDir. A subdirectory that
+// should have had a listing but has none, because it failed with
+// --keep-going, is read separately after all.
+func (ctx *dirContext) useChildListings(byDir map[string]*dirContext) {
+  data := &ctx.templateData
+
+  for i := range data.Items {
+    item := &data.Items[i]
+    path := filepath.Join(ctx.dirAbsolute, item.Name)
+
+    if !item.IsDir || !ctx.listedDirs[path] {
+      continue
+    }
+
+    child, ok := byDir[path]
+
+    if !ok {
+      // the total includes the size that measureDir replaces
+      before := item.Size
+      ctx.measureDir(item)
+
+      if ctx.computeDirSizes && !item.IsSymlink {
+        data.TotalSize += item.Size - before
+      }
+
+      continue
+    }
+
+    if ctx.countChildren {
+      item.ChildCount = child.templateData.NumItems
+    }
+
+    if ctx.computeDirSizes {
+      // symlinks aren't part of the total, as in fetchData
+      if !item.IsSymlink {
+        data.TotalSize += child.templateData.TotalSize - item.Size
+      }
+
+      item.Size = child.templateData.TotalSize
+      item.sizeComputed = true
+    }
+  }
+
+  // the sizes may have changed the order
+  if ctx.computeDirSizes {
+    ctx.sortItems()
+  }
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+  "path/filepath"
+  "testing"
+)
+
+func TestTwoPassDoesNotReadListedChildren(t *testing.T) {
+  root := t.TempDir()
+  writeTree(t, root, map[string]string{"sub/a.txt": "abc", "sub/deep/b.txt": "de"})
+
+  runner := newRunner(
+    t, "-q", "-r", "--two-pass", "--compute-dir-sizes", "--count-children",
+    "--root", root, root,
+  )
+
+  ctx, err := runner.prepare(root)
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  ctx.listedDirs = map[string]bool{filepath.Join(root, "sub"): true}
+
+  if err := ctx.fetchData(); err != nil {
+    t.Fatal(err)
+  }
+
+  items := ctx.templateData.Items
+
+  if len(items) != 1 || items[0].Name != "sub" {
+    t.Fatalf("expected only sub, got %v", itemNames(items))
+  }
+
+  if items[0].sizeComputed || items[0].ChildCount != 0 {
+    t.Errorf(
+      "expected sub to be left to its own listing, got size %d and %d entries",
+      items[0].Size, items[0].ChildCount,
+    )
+  }
+}
+
+func TestTwoPassCountsAndSizes(t *testing.T) {
+  root := t.TempDir()
+  writeTree(t, root, map[string]string{
+    "a/file.txt": "abc",
+    "a/b/file.txt": "de",
+    "a/b/c/file.txt": "f",
+  })
+
+  // b is below --max-depth, so it has no listing and is read separately
+  err := runIndexify(
+    t, "-q", "-r", "--two-pass", "--max-depth", "1", "--compute-dir-sizes",
+    "--count-children", "--root", root, "--format", "json", root,
+  )
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  tests := []struct {
+    dir string
+    name string
+    size int64
+    count int
+  }{
+    {root, "a", 6, 2},
+    {filepath.Join(root, "a"), "b", 3, 2},
+  }
+
+  for _, tt := range tests {
+    listing := readListing(t, tt.dir)
+    var item *DirectoryItem
+
+    for i := range listing.Items {
+      if listing.Items[i].Name == tt.name {
+        item = &listing.Items[i]
+      }
+    }
+
+    if item == nil {
+      t.Fatalf("expected %s in %s, got %v", tt.name, tt.dir, itemNames(listing.Items))
+    }
+
+    if item.Size != tt.size || item.ChildCount != tt.count {
+      t.Errorf(
+        "expected %s to have size %d and %d entries, got %d and %d",
+        tt.name, tt.size, tt.count, item.Size, item.ChildCount,
+      )
+    }
+  }
+
+  if total := readListing(t, root).TotalSize; total != 6 {
+    t.Errorf("expected a total size of 6, got %d", total)
+  }
+}
@@ -0,0 +1,189 @@
+package cmd
+
+import (
+  "os"
+  "os/signal"
+  "path/filepath"
+  "sort"
+  "strings"
+  "syscall"
+  "time"
+
+  "github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce is how long --watch waits for things to settle down before
+// regenerating, so that copying a batch of files causes a single rebuild.
+const watchDebounce = 200 * time.Millisecond
+
+// watchForChanges regenerates the index of every directory whose contents
+// change, until interrupted. With --recursive, directories created while
+// watching are watched and indexed as well. Errors while regenerating are
+// reported but don't stop the watch.
+func (runner *RootCmdRunner) watchForChanges() error {
+  watcher, err := fsnotify.NewWatcher()
+
+  if err != nil {
+    return err
+  }
+
+  defer watcher.Close()
+
+  err = runner.walkStartDirs(watcher.Add)
+
+  if err != nil {
+    return err
+  }
+
+  interrupt := make(chan os.Signal, 1)
+  signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+  defer signal.Stop(interrupt)
+
+  pending := map[string]bool{}
+  timer := time.NewTimer(watchDebounce)
+  timer.Stop()
+
+  runner.logf(
+    logNormal, "watching %s for changes", strings.Join(runner.startDirs, ", "),
+  )
+
+  for {
+    select {
+    case <-interrupt:
+      return nil
+
+    case err, ok := <-watcher.Errors:
+      if !ok {
+        return nil
+      }
+
+      runner.logError(err)
+
+    case event, ok := <-watcher.Events:
+      if !ok {
+        return nil
+      }
+
+      if !runner.isRelevantEvent(event) {
+        continue
+      }
+
+      pending[filepath.Dir(event.Name)] = true
+
+      if runner.recursive && event.Has(fsnotify.Create) {
+        err = runner.watchNewDir(watcher, event.Name, pending)
+
+        if err != nil {
+          runner.logError(err)
+        }
+      }
+
+      timer.Reset(watchDebounce)
+
+    case <-timer.C:
+      runner.regenerate(pending)
+      pending = map[string]bool{}
+    }
+  }
+}
+
+// isRelevantEvent reports whether event could change a listing. Changes to
+// files indexify writes itself are ignored, or every rebuild would trigger
+// the next one.
+func (runner *RootCmdRunner) isRelevantEvent(event fsnotify.Event) bool {
+  if event.Op == fsnotify.Chmod {
+    return false
+  }
+
+  dir, err := filepath.Abs(filepath.Dir(event.Name))
+
+  if err != nil {
+    return false
+  }
+
+  name := filepath.Base(event.Name)
+
+  if name == indexignoreName {
+    return true
+  }
+
+  if !runner.includeHidden && strings.HasPrefix(name, ".") {
+    return false
+  }
+
+  return !runner.isOutputName(dir, name) && !runner.isTempName(dir, name)
+}
+
+// isTempName reports whether name is one of the temporary files that
+// writeFileAtomic creates next to an output file in dir.
+func (runner *RootCmdRunner) isTempName(dir string, name string) bool {
+  i := strings.LastIndex(name, ".tmp")
+
+  return strings.HasPrefix(name, ".") && i > 1 &&
+    runner.isOutputName(dir, name[1:i])
+}
+
+// watchNewDir starts watching path and the directories below it, if path is
+// a directory the walk would have included, and queues them for indexing.
+func (runner *RootCmdRunner) watchNewDir(
+  watcher *fsnotify.Watcher,
+  path string,
+  pending map[string]bool,
+) error {
+  info, err := os.Lstat(path)
+
+  if err != nil || !info.IsDir() {
+    return nil
+  }
+
+  if runner.isExcluded(info.Name()) {
+    return nil
+  }
+
+  absPath, err := filepath.Abs(path)
+
+  if err != nil {
+    return err
+  }
+
+  if runner.isExcludedPath(absPath) {
+    return nil
+  }
+
+  if runner.gitignore && runner.isGitignored(absPath, true) {
+    return nil
+  }
+
+  return runner.walkTree(runner.startDirOf(path), path, visitedDirs{}, func(dir string) error {
+    pending[dir] = true
+    return watcher.Add(dir)
+  })
+}
+
+// regenerate indexes the pending directories again, skipping any that have
+// been removed in the meantime.
+func (runner *RootCmdRunner) regenerate(pending map[string]bool) {
+  dirs := make([]string, 0, len(pending))
+
+  for dir := range pending {
+    dirs = append(dirs, dir)
+  }
+
+  sort.Strings(dirs)
+
+  for _, dir := range dirs {
+    if _, err := os.Stat(dir); err != nil {
+      continue
+    }
+
+    if err := runner.processDirectory(dir); err != nil {
+      runner.logError(err)
+    }
+  }
+
+  if runner.sitemap {
+    if err := runner.renderSitemap(); err != nil {
+      runner.logError(err)
+    }
+  }
+}